@@ -36,6 +36,34 @@ variable "gha_version" {
   default = "2.313.0" # https://api.github.com/repos/actions/runner/releases/latest
 }
 
+variable "macos_version" {
+  type    = string
+  default = "14"
+}
+
+# the runner labels the image's VMs register with, comma separated
+variable "runner_labels" {
+  type    = string
+  default = "tramline-macos-sonoma-md"
+}
+
+variable "image_version" {
+  type    = string
+  default = ""
+}
+
+# where to publish the built image to, skipped when empty
+variable "buildkansen_images_url" {
+  type    = string
+  default = ""
+}
+
+variable "internal_api_token" {
+  type      = string
+  default   = ""
+  sensitive = true
+}
+
 source "tart-cli" "tart" {
   vm_base_name = "${var.base_vm}"
   vm_name      = "${var.vm_name}"
@@ -212,4 +240,19 @@ build {
       "chmod 600 ~/.ssh/authorized_keys",
     ]
   }
+
+  # register the image with buildkansen
+  post-processor "shell-local" {
+    environment_vars = [
+      "IMAGES_URL=${var.buildkansen_images_url}",
+      "INTERNAL_API_TOKEN=${var.internal_api_token}",
+    ]
+    inline = [
+      "[ -z \"$IMAGES_URL\" ] && echo 'Not publishing the image' && exit 0",
+      "checksum=$(shasum -a 256 ~/.tart/vms/${var.vm_name}/disk.img | cut -d ' ' -f 1)",
+      "version=${var.image_version}",
+      "[ -z \"$version\" ] && version=$(date -u +%Y%m%d%H%M%S)",
+      "curl -sf -XPUT -H \"Authorization: Bearer $INTERNAL_API_TOKEN\" -H 'Content-Type: application/json' -d \"{\\\"name\\\": \\\"${var.vm_name}\\\", \\\"version\\\": \\\"$version\\\", \\\"macos_version\\\": \\\"${var.macos_version}\\\", \\\"xcode_version\\\": \\\"${var.xcode_version}\\\", \\\"runner_version\\\": \\\"${var.gha_version}\\\", \\\"labels\\\": \\\"${var.runner_labels}\\\", \\\"checksum\\\": \\\"$checksum\\\"}\" \"$IMAGES_URL\"",
+    ]
+  }
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	"os"
+	"os/user"
+	"strings"
+)
+
+// audit records an action an operator took on the command line, as their login on this machine
+func audit(action string, target core.AuditTarget, before interface{}, after interface{}) {
+	event := models.AuditEvent{
+		ActorKind: models.AuditActorCli,
+		Action:    action,
+		Method:    "CLI",
+		Path:      strings.Join(os.Args[1:], " "),
+	}
+
+	if operator, err := user.Current(); err == nil {
+		event.ActorLogin = operator.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		event.UserAgent = "buildkansen on " + hostname
+	}
+
+	core.RecordAudit(&event, target, before, after)
+}
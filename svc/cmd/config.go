@@ -0,0 +1,41 @@
+package main
+
+import (
+	"buildkansen/config"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const configUsage = `usage: buildkansen config <command>
+
+  dump [-redacted]  print the effective configuration and where each setting came from, then what is invalid`
+
+// redacted stands in for the value of a secret that is set
+const redacted = "[redacted]"
+
+// configCommand prints the configuration, it reads it itself so an invalid one can be looked at
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "dump" {
+		fmt.Println(configUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("config dump", flag.ExitOnError)
+	redact := flags.Bool("redacted", false, "hide the values of secrets")
+	_ = flags.Parse(args[1:])
+
+	c, err := config.Read()
+	for _, setting := range c.Settings() {
+		value := setting.Value
+		if *redact && setting.Secret && len(value) > 0 {
+			value = redacted
+		}
+		fmt.Printf("%s=%s  # %s\n", setting.Key, value, setting.Source)
+	}
+
+	if err != nil {
+		fmt.Printf("\nInvalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+}
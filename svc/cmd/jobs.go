@@ -0,0 +1,116 @@
+package main
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/log"
+	"buildkansen/models"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const jobsUsage = `usage: buildkansen jobs <command> [arguments]
+
+  list            list the queued and running jobs, or -state stranded for those we failed to start
+  requeue <id>    put a job that never started on its runner back in the queue
+  cancel <id>     cancel a job that has not ended
+
+Jobs are given by the id list shows, the same the admin console uses.`
+
+// jobsCommand lists, requeues or cancels jobs, as operators do from the admin console
+func jobsCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(jobsUsage)
+		os.Exit(2)
+	}
+
+	connect()
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("jobs list", flag.ExitOnError)
+		state := flags.String("state", "", "only the queued, running or stranded jobs")
+		_ = flags.Parse(args[1:])
+		listJobs(*state)
+	case "requeue":
+		jobRun := findJob(args[1:])
+		if appError := core.RequeueJob(jobRun); appError != nil {
+			log.Fatalf("%s: %s", appError.Message, appError.Error)
+		}
+
+		audit("run.requeue", core.RunTarget(jobRun), core.RunSnapshot(jobRun), nil)
+		fmt.Printf("requeued job %d\n", jobRun.InternalId)
+	case "cancel":
+		jobRun := findJob(args[1:])
+		if appError := core.CancelJob(jobRun); appError != nil {
+			log.Fatalf("%s: %s", appError.Message, appError.Error)
+		}
+
+		audit("run.cancel", core.RunTarget(jobRun), core.RunSnapshot(jobRun), nil)
+		fmt.Printf("cancelled job %d\n", jobRun.InternalId)
+	default:
+		fmt.Println(jobsUsage)
+		os.Exit(2)
+	}
+}
+
+func listJobs(state string) {
+	fetchers := map[string]func() ([]models.WorkflowJobRun, error){
+		"queued":   models.PendingWorkflowJobRuns,
+		"running":  models.RunningWorkflowJobRuns,
+		"stranded": models.StrandedWorkflowJobRuns,
+	}
+
+	states := []string{"queued", "running"}
+	if len(state) > 0 {
+		if _, found := fetchers[state]; !found {
+			fmt.Println(jobsUsage)
+			os.Exit(2)
+		}
+		states = []string{state}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tREPOSITORY\tWORKFLOW\tJOB\tLABELS\tSTARTED\tVM")
+	for _, state := range states {
+		jobRuns, err := fetchers[state]()
+		if err != nil {
+			log.Fatalf("Error fetching the %s jobs: %s", state, err)
+		}
+
+		for _, jobRun := range jobRuns {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				jobRun.InternalId,
+				state,
+				jobRun.Repository.FullName,
+				jobRun.WorkflowName,
+				jobRun.Name,
+				jobRun.Labels,
+				jobRun.StartedAt.Format("2006-01-02 15:04:05"),
+				orDash(jobRun.VMInstanceName))
+		}
+	}
+	_ = w.Flush()
+}
+
+// findJob looks up the job given by the first argument, or exits
+func findJob(args []string) *models.WorkflowJobRun {
+	if len(args) != 1 {
+		fmt.Println(jobsUsage)
+		os.Exit(2)
+	}
+
+	internalId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Println(jobsUsage)
+		os.Exit(2)
+	}
+
+	jobRun, err := models.FindAnyRun(internalId)
+	if err != nil {
+		log.Fatalf("Job %d not found: %s", internalId, err)
+	}
+
+	return jobRun
+}
@@ -3,17 +3,73 @@ package main
 import (
 	"buildkansen/config"
 	"buildkansen/db"
-	"buildkansen/internal/jobs"
 	"buildkansen/log"
 	"buildkansen/models"
-	"buildkansen/web"
+	"fmt"
+	"os"
+	"strings"
 )
 
+const usage = `usage: buildkansen <command> [arguments]
+
+  serve      run the web server, the scheduler of this host and the background jobs, -role picks some (the default command)
+  worker     run the scheduler of this host and the background jobs only, the same as serve -role scheduler
+  migrate    apply, revert or list the database migrations
+  vm         bind, unbind or list the pool's VMs
+  jobs       list, requeue or cancel jobs
+  reconcile  fail runs that lost their VM and list VMs their host has not torn down
+  config     print the effective configuration
+  secrets    manage the secrets in the encrypted keystore
+
+Run buildkansen <command> -h for a command's arguments.`
+
 func main() {
 	log.Init()
+
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "config":
+		configCommand(args)
+		return
+	case "secrets":
+		secretsCommand(args)
+		return
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return
+	}
+
+	commands := map[string]func(args []string){
+		"serve":     serve,
+		"worker":    worker,
+		"migrate":   migrateCommand,
+		"vm":        vmCommand,
+		"jobs":      jobsCommand,
+		"reconcile": reconcileCommand,
+	}
+	command, found := commands[name]
+	if !found {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
 	config.Load()
+	command(args)
+}
+
+// connect opens the database, and refuses to go on if its schema is behind this build
+func connect() {
 	db.Init()
-	models.Migrate()
-	jobs.Start()
-	web.Run()
+	models.RequireMigrated()
+}
+
+// localHost is this machine's name the way the host scripts report it, without its domain
+func localHost() string {
+	hostname, _ := os.Hostname()
+	short, _, _ := strings.Cut(hostname, ".")
+	return short
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"buildkansen/db"
+	"buildkansen/log"
+	"buildkansen/models"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = `usage: buildkansen migrate <command>
+
+  up        apply every pending migration
+  down [n]  revert the last n applied migrations, 1 by default
+  status    list the migrations and whether they are applied`
+
+// migrateCommand applies, reverts or lists the migrations
+func migrateCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+
+	db.Init()
+	switch args[0] {
+	case "up":
+		migrated, err := models.MigrateUp()
+		for _, migration := range migrated {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("Error migrating the database: %s", err)
+		}
+		if len(migrated) == 0 {
+			fmt.Println("the database is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Println(migrateUsage)
+				os.Exit(2)
+			}
+			steps = n
+		}
+
+		reverted, err := models.MigrateDown(steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("Error reverting the database: %s", err)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no migrations are applied")
+		}
+	case "status":
+		statuses, err := models.MigrationStatuses()
+		if err != nil {
+			log.Fatalf("Error reading the migrations: %s", err)
+		}
+
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt.Valid {
+				state = "applied " + status.AppliedAt.Time.Format("2006-01-02 15:04:05")
+			}
+			if status.Unknown {
+				state += ", not in this build"
+			}
+			fmt.Printf("%04d  %-30s  %s\n", status.Version, status.Name, state)
+		}
+	default:
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/log"
+	"flag"
+	"fmt"
+)
+
+// reconcileCommand puts the runs back in step with the pool, or with -dry-run only lists what is out of step
+func reconcileCommand(args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "list what is out of step without changing anything")
+	_ = flags.Parse(args)
+
+	connect()
+	reconciliation, err := core.Reconcile(*dryRun)
+	if err != nil {
+		log.Fatalf("Error reconciling: %s", err)
+	}
+
+	for _, vm := range reconciliation.OrphanedVMs {
+		fmt.Printf("VM %d (%s on %s) is held for a run that has ended or is gone, is the scheduler of %s running?\n",
+			vm.Id, vm.VMInstanceName, vm.Host, vm.Host)
+	}
+	for _, jobRun := range reconciliation.LostRuns {
+		fmt.Printf("job %d (%s) lost its VM %s\n", jobRun.InternalId, jobRun.Repository.FullName, jobRun.VMInstanceName)
+	}
+	if *dryRun {
+		return
+	}
+
+	for i := range reconciliation.FailedRuns {
+		audit("run.fail", core.RunTarget(&reconciliation.FailedRuns[i]), core.RunSnapshot(&reconciliation.FailedRuns[i]), nil)
+	}
+	fmt.Printf("failed %d lost jobs and swept stale runners\n", len(reconciliation.FailedRuns))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"buildkansen/config"
+	"buildkansen/log"
+	"buildkansen/secrets"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const secretsUsage = `usage: buildkansen secrets <command>
+
+  keygen                        print a new key for a keystore
+  list                          list the names of the secrets in the keystore
+  set <name>                    store the secret read from stdin
+  rm <name>                     remove the secret
+  rotate <name> [-overlap 24h]  replace INTERNAL_API_TOKEN or SESSION_SECRET with the one read from stdin, or a
+                                generated one, accepting the old one too until the overlap ends
+
+The keystore is the file SECRETS_KEYSTORE names, encrypted with SECRETS_KEY.`
+
+// rotatable are the secrets that can be accepted alongside their previous value while everything moves over
+var rotatable = []string{"INTERNAL_API_TOKEN", "SESSION_SECRET"}
+
+// secretsCommand manages the secrets in the encrypted keystore
+func secretsCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(secretsUsage)
+		os.Exit(2)
+	}
+
+	if args[0] == "keygen" {
+		key, err := secrets.NewKey()
+		if err != nil {
+			log.Fatalf("Error generating a key: %s", err)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	keystore, err := config.OpenKeystore()
+	if err != nil {
+		log.Fatalf("Error opening the keystore:\n%s", err)
+	}
+
+	switch args[0] {
+	case "list":
+		for _, name := range keystore.Names() {
+			fmt.Println(name)
+		}
+		return
+	case "set":
+		name := requireName(args)
+		keystore.Set(name, readSecret())
+		saveKeystore(keystore)
+		fmt.Printf("stored %s\n", name)
+	case "rm":
+		name := requireName(args)
+		keystore.Remove(name)
+		saveKeystore(keystore)
+		fmt.Printf("removed %s\n", name)
+	case "rotate":
+		name := requireName(args)
+		if !isRotatable(name) {
+			fmt.Printf("only %s can be rotated\n", strings.Join(rotatable, " and "))
+			os.Exit(2)
+		}
+
+		flags := flag.NewFlagSet("secrets rotate", flag.ExitOnError)
+		overlap := flags.Duration("overlap", 24*time.Hour, "how long the old secret is still accepted")
+		_ = flags.Parse(args[2:])
+
+		current, found, _ := keystore.Lookup(name)
+		if !found {
+			fmt.Printf("there is no %s to rotate, set it first\n", name)
+			os.Exit(1)
+		}
+
+		next := readSecret()
+		generated := len(next) == 0
+		if generated {
+			next = generateSecret()
+		}
+
+		until := time.Now().Add(*overlap).UTC()
+		keystore.Set(name+"_PREVIOUS", current)
+		keystore.Set(name+"_PREVIOUS_UNTIL", until.Format(time.RFC3339))
+		keystore.Set(name, next)
+		saveKeystore(keystore)
+
+		if generated {
+			fmt.Println(next)
+		}
+		fmt.Printf("rotated %s, the old one is accepted until %s. Restart the service to pick the new one up, the old one stops being accepted on its own\n", name, until.Format(time.RFC3339))
+	default:
+		fmt.Println(secretsUsage)
+		os.Exit(2)
+	}
+}
+
+func requireName(args []string) string {
+	if len(args) < 2 || len(args[1]) == 0 {
+		fmt.Println(secretsUsage)
+		os.Exit(2)
+	}
+
+	return args[1]
+}
+
+func isRotatable(name string) bool {
+	for _, r := range rotatable {
+		if r == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readSecret reads the secret from stdin, without the newline it may end with
+func readSecret() string {
+	contents, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatalf("Error reading the secret: %s", err)
+	}
+
+	return strings.TrimRight(string(contents), "\r\n")
+}
+
+func generateSecret() string {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Error generating a secret: %s", err)
+	}
+
+	return hex.EncodeToString(secret)
+}
+
+func saveKeystore(keystore *secrets.Keystore) {
+	if err := keystore.Save(); err != nil {
+		log.Fatalf("Error saving the keystore: %s", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"buildkansen/internal/events"
+	"buildkansen/internal/jobs"
+	"buildkansen/internal/priority"
+	"buildkansen/web"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const (
+	roleAll       = "all"
+	roleWeb       = "web"
+	roleScheduler = "scheduler"
+)
+
+// serve runs the web server and the scheduler in one process, or only one of them with -role
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	role := flags.String("role", roleAll, "web, scheduler, or all for both")
+	host := flags.String("host", localHost(), "the host whose VMs the scheduler boots and tears down")
+	_ = flags.Parse(args)
+
+	if *role != roleAll && *role != roleWeb && *role != roleScheduler {
+		fmt.Printf("unknown role %s, it is web, scheduler or all\n", *role)
+		os.Exit(2)
+	}
+
+	run(*role, *host)
+}
+
+// worker runs the scheduler without the web server
+func worker(args []string) {
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	host := flags.String("host", localHost(), "the host whose VMs the scheduler boots and tears down")
+	_ = flags.Parse(args)
+
+	run(roleScheduler, *host)
+}
+
+func run(role string, host string) {
+	priority.Init()
+	connect()
+	if role != roleWeb {
+		jobs.Start(host)
+	}
+	if role == roleScheduler {
+		select {}
+	}
+
+	go events.Listen()
+	web.Run()
+}
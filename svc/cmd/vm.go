@@ -0,0 +1,123 @@
+package main
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/log"
+	"buildkansen/models"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+const vmUsage = `usage: buildkansen vm <command> [arguments]
+
+  bind    park a VM cloned from a base VM in the pool
+  unbind  take a host's free VMs cloned from a base VM out of the pool
+  list    list the pool's VMs and the jobs they are running`
+
+// vmCommand binds, unbinds or lists the pool's VMs, as the host scripts do through the internal API
+func vmCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(vmUsage)
+		os.Exit(2)
+	}
+
+	connect()
+	flags := flag.NewFlagSet("vm "+args[0], flag.ExitOnError)
+	host := flags.String("host", localHost(), "the host the VM runs on")
+
+	switch args[0] {
+	case "bind":
+		baseVMName := flags.String("base", "", "the base VM the VM is cloned from")
+		label := flags.String("label", "", "the runner labels the VM serves, comma separated, they must be its image's")
+		image := flags.String("image", "", "the name of the published image the VM was parked from")
+		imageVersion := flags.String("image-version", "", "the version of the VM's image, the latest if not given")
+		_ = flags.Parse(args[1:])
+		requireFlags(flags, *baseVMName, *image)
+
+		vm, appError := core.BindVM(*baseVMName, *label, *host, *image, *imageVersion)
+		if appError != nil {
+			log.Fatalf("%s: %s", appError.Message, appError.Error)
+		}
+
+		audit("vm.bind", core.VMTarget(vm), nil, core.VMSnapshot(vm))
+		fmt.Printf("bound VM %d from %s on %s\n", vm.Id, vm.BaseVMName, vm.Host)
+	case "unbind":
+		baseVMName := flags.String("base", "", "the base VM the VMs are cloned from")
+		_ = flags.Parse(args[1:])
+		requireFlags(flags, *baseVMName)
+
+		removed, busy, appError := core.UnbindVMs(*baseVMName, *host)
+		if appError != nil {
+			log.Fatalf("%s: %s", appError.Message, appError.Error)
+		}
+
+		for i := range removed {
+			audit("vm.unbind", core.VMTarget(&removed[i]), core.VMSnapshot(&removed[i]), nil)
+		}
+		fmt.Printf("unbound %d VMs, %d running a job stay until they're free\n", len(removed), busy)
+		if len(removed) == 0 {
+			os.Exit(1)
+		}
+	case "list":
+		_ = flags.Parse(args[1:])
+		listVMs(flags, *host)
+	default:
+		fmt.Println(vmUsage)
+		os.Exit(2)
+	}
+}
+
+// listVMs prints the VMs, only the host's if it was asked for
+func listVMs(flags *flag.FlagSet, host string) {
+	vms, err := models.FetchVMs()
+	if err != nil {
+		log.Fatalf("Error fetching the VMs: %s", err)
+	}
+	vmRuns, err := models.OpenRunsByVMInstance()
+	if err != nil {
+		log.Fatalf("Error fetching the runs: %s", err)
+	}
+
+	onlyHost := false
+	flags.Visit(func(f *flag.Flag) { onlyHost = onlyHost || f.Name == "host" })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tHOST\tBASE VM\tLABEL\tIMAGE\tSTATUS\tINSTANCE\tRUN")
+	for _, vm := range vms {
+		if onlyHost && vm.Host != host {
+			continue
+		}
+
+		image := "-"
+		if vm.Image != nil {
+			image = vm.Image.Name + ":" + vm.Image.Version
+		}
+		run := "-"
+		if jobRun, found := vmRuns[vm.VMInstanceName]; found && len(vm.VMInstanceName) > 0 {
+			run = fmt.Sprintf("%d (%s)", jobRun.InternalId, jobRun.Repository.FullName)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", vm.Id, vm.Host, vm.BaseVMName, vm.GithubRunnerLabel, image, vm.Status, orDash(vm.VMInstanceName), run)
+	}
+	_ = w.Flush()
+}
+
+// requireFlags exits with the flags' usage if any of the values is empty
+func requireFlags(flags *flag.FlagSet, values ...string) {
+	for _, value := range values {
+		if len(value) == 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+	}
+}
+
+func orDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+
+	return value
+}
@@ -2,72 +2,195 @@ package config
 
 import (
 	"buildkansen/log"
+	"buildkansen/secrets"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
 	"github.com/joho/godotenv"
+	"net/url"
 	"os"
-	"strconv"
+	"time"
 )
 
 type AppConfig struct {
-	AppEnv                       string
-	DbName                       string
-	DbConnectionString           string
-	SessionName                  string
-	SessionSecret                string
+	AppEnv             string
+	DbConnectionString string
+	SessionName        string
+	SessionSecret      string
+	// PreviousSessionSecret still opens sessions signed before the rotation, until PreviousSessionSecretUntil
+	PreviousSessionSecret        string
+	PreviousSessionSecretUntil   time.Time
 	GithubAppUrl                 string
 	GithubAppId                  int64
 	GithubClientID               string
 	GithubClientSecret           string
 	GithubAuthRedirectUrl        string
 	GithubAppRedirectUrl         string
-	GithubAppInstallationBaseUrl string
 	GithubPrivateKeyBase64       string
 	GithubNewInstallationUrl     string
 	AuthorizedUserInSessionKey   string
+	ImpersonatedUserInSessionKey string
+	SignedInAtInSessionKey       string
+	CsrfTokenInSessionKey        string
+	InstallStateInSessionKey     string
+	SessionMaxAgeHours           int64
 	InternalApiToken             string
-	ValidRunnerNames             []string
+	// PreviousInternalApiToken is still accepted until PreviousInternalApiTokenUntil
+	PreviousInternalApiToken      string
+	PreviousInternalApiTokenUntil time.Time
+	SecretsProvider               string
+	AppUrl                        string
+	GithubChecksEnabled           bool
+	QueueSlaMinutes               int64
+	RunnerReleasesUrl             string
+	RunnerUpdateGraceDays         int64
+	RunnerWarnDays                int64
+	PriorityRulesPath             string
+	PriorityAgingMinutes          int64
+	DefaultMaxRuntimeMinutes      int64
+	AdminUserIds                  []int64
+	DeletionGraceDays             int64
+	RunRetentionDays              int64
+
+	settings []Setting
 }
 
+// defaultConfigFile is read when CONFIG_FILE doesn't name one, if it exists
+const defaultConfigFile = "buildkansen.json"
+
 var C *AppConfig
 
+// Load reads the configuration into C, see Read, and refuses to go on when any of it is missing or invalid
 func Load() {
-	err := godotenv.Load()
+	config, err := Read()
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		log.Fatalf("Invalid configuration:\n%s", err)
 		panic(err)
 	}
 
-	C = &AppConfig{
-		AppEnv:                       getEnv("ENV", "development"),
-		DbName:                       getEnv("APP_NAME", ""),
-		DbConnectionString:           getEnv("DB_CONNECTION_STRING", ""),
-		SessionName:                  getEnv("APP_NAME", ""),
-		SessionSecret:                getEnv("SESSION_SECRET", ""),
-		GithubAppUrl:                 getEnv("GITHUB_APP_URL", ""),
-		GithubAppId:                  parseInt64Env("GITHUB_APP_ID", 0),
-		GithubClientID:               getEnv("GITHUB_CLIENT_ID", ""),
-		GithubClientSecret:           getEnv("GITHUB_CLIENT_SECRET", ""),
-		GithubAuthRedirectUrl:        getEnv("GITHUB_AUTH_REDIRECT_URL", ""),
-		GithubAppRedirectUrl:         getEnv("GITHUB_APP_REDIRECT_URL", ""),
-		GithubPrivateKeyBase64:       getEnv("GITHUB_PRIVATE_KEY_BASE64", ""),
-		GithubAppInstallationBaseUrl: getEnv("GITHUB_NEW_INSTALLATION_URL", ""),
-		InternalApiToken:             getEnv("INTERNAL_API_TOKEN", ""),
-		AuthorizedUserInSessionKey:   "User ID",
-		ValidRunnerNames:             []string{"tramline-macos-sonoma-md"},
+	C = config
+}
+
+// Read reads the configuration from the environment, <NAME>_FILE files and the config file, listing every invalid setting
+func Read() (*AppConfig, error) {
+	l := load()
+	provider := l.secretsProvider()
+
+	c := &AppConfig{
+		AppEnv:                        l.string("ENV", "development"),
+		DbConnectionString:            l.required("DB_CONNECTION_STRING", true),
+		SessionName:                   l.string("SESSION_NAME", l.string("APP_NAME", "buildkansen")),
+		SessionSecret:                 l.secret("SESSION_SECRET", true),
+		PreviousSessionSecret:         l.secret("SESSION_SECRET_PREVIOUS", false),
+		PreviousSessionSecretUntil:    l.until("SESSION_SECRET_PREVIOUS_UNTIL"),
+		GithubAppUrl:                  l.string("GITHUB_APP_URL", ""),
+		GithubAppId:                   l.int64("GITHUB_APP_ID", 0, 1),
+		GithubClientID:                l.required("GITHUB_CLIENT_ID", false),
+		GithubClientSecret:            l.secret("GITHUB_CLIENT_SECRET", true),
+		GithubAuthRedirectUrl:         l.required("GITHUB_AUTH_REDIRECT_URL", false),
+		GithubAppRedirectUrl:          l.required("GITHUB_APP_REDIRECT_URL", false),
+		GithubPrivateKeyBase64:        l.secret("GITHUB_PRIVATE_KEY_BASE64", true),
+		GithubNewInstallationUrl:      l.required("GITHUB_NEW_INSTALLATION_URL", false),
+		InternalApiToken:              l.secret("INTERNAL_API_TOKEN", true),
+		PreviousInternalApiToken:      l.secret("INTERNAL_API_TOKEN_PREVIOUS", false),
+		PreviousInternalApiTokenUntil: l.until("INTERNAL_API_TOKEN_PREVIOUS_UNTIL"),
+		AuthorizedUserInSessionKey:    "User ID",
+		ImpersonatedUserInSessionKey:  "Impersonated User ID",
+		SignedInAtInSessionKey:        "Signed In At",
+		CsrfTokenInSessionKey:         "CSRF Token",
+		InstallStateInSessionKey:      "Install State",
+		SessionMaxAgeHours:            l.int64("SESSION_MAX_AGE_HOURS", 168, 1),
+		AppUrl:                        l.string("APP_URL", "https://localhost:8081"),
+		GithubChecksEnabled:           l.bool("GITHUB_CHECKS_ENABLED", false),
+		QueueSlaMinutes:               l.int64("QUEUE_SLA_MINUTES", 30, 1),
+		RunnerReleasesUrl:             l.string("RUNNER_RELEASES_URL", "https://api.github.com/repos/actions/runner/releases?per_page=100"),
+		RunnerUpdateGraceDays:         l.int64("RUNNER_UPDATE_GRACE_DAYS", 30, 0),
+		RunnerWarnDays:                l.int64("RUNNER_WARN_DAYS", 7, 0),
+		PriorityRulesPath:             l.string("PRIORITY_RULES_PATH", ""),
+		PriorityAgingMinutes:          l.int64("PRIORITY_AGING_MINUTES", 15, 1),
+		DefaultMaxRuntimeMinutes:      l.int64("DEFAULT_MAX_RUNTIME_MINUTES", 360, 1),
+		AdminUserIds:                  l.int64List("ADMIN_USER_IDS"),
+		DeletionGraceDays:             l.int64("DELETION_GRACE_DAYS", 30, 0),
+		RunRetentionDays:              l.int64("RUN_RETENTION_DAYS", 0, 0),
+	}
+	c.SecretsProvider = provider.Name()
+	c.settings = l.settings
+
+	if len(c.GithubPrivateKeyBase64) > 0 {
+		_, err := base64.StdEncoding.DecodeString(c.GithubPrivateKeyBase64)
+		l.check(err == nil, "GITHUB_PRIVATE_KEY_BASE64 is not base64: %v", err)
 	}
+	for _, setting := range [][2]string{
+		{"APP_URL", c.AppUrl},
+		{"GITHUB_AUTH_REDIRECT_URL", c.GithubAuthRedirectUrl},
+		{"GITHUB_APP_REDIRECT_URL", c.GithubAppRedirectUrl},
+	} {
+		key, value := setting[0], setting[1]
+		if len(value) > 0 {
+			u, err := url.Parse(value)
+			l.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0, "%s is not an http(s) URL: %q", key, value)
+		}
+	}
+	l.check(len(c.PreviousSessionSecret) == 0 || !c.PreviousSessionSecretUntil.IsZero(),
+		"SESSION_SECRET_PREVIOUS_UNTIL is required with SESSION_SECRET_PREVIOUS")
+	l.check(len(c.PreviousInternalApiToken) == 0 || !c.PreviousInternalApiTokenUntil.IsZero(),
+		"INTERNAL_API_TOKEN_PREVIOUS_UNTIL is required with INTERNAL_API_TOKEN_PREVIOUS")
+	if len(c.PriorityRulesPath) > 0 {
+		_, err := os.Stat(c.PriorityRulesPath)
+		l.check(err == nil, "PRIORITY_RULES_PATH: %v", err)
+	}
+
+	return c, l.err()
 }
 
-func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// OpenKeystore opens the keystore SECRETS_KEYSTORE names with the key in SECRETS_KEY
+func OpenKeystore() (*secrets.Keystore, error) {
+	l := load()
+	keystore := l.keystore()
+	if err := l.err(); err != nil {
+		return nil, err
 	}
-	return defaultValue
+
+	return keystore, nil
 }
 
-func parseInt64Env(key string, defaultValue int64) int64 {
-	if valueStr, exists := os.LookupEnv(key); exists {
-		if value, err := strconv.ParseInt(valueStr, 10, 64); err == nil {
-			return value
+// load fills the environment in from the .env file and reads the config file
+func load() *loader {
+	var errs []string
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, fmt.Sprintf("could not load the .env file: %s", err))
+	}
+
+	path, required := os.LookupEnv("CONFIG_FILE")
+	if !required {
+		path = defaultConfigFile
+	}
+	l := newLoader(path, required)
+	l.errs = append(errs, l.errs...)
+
+	return l
+}
+
+// IsInternalApiToken is true for the internal API token, or the previous one while its overlap lasts
+func (c *AppConfig) IsInternalApiToken(token string) bool {
+	for _, accepted := range overlapping(c.InternalApiToken, c.PreviousInternalApiToken, c.PreviousInternalApiTokenUntil) {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(accepted)) == 1 {
+			return true
 		}
 	}
-	return defaultValue
+
+	return false
+}
+
+func overlapping(current string, previous string, until time.Time) []string {
+	if len(previous) == 0 || time.Now().After(until) {
+		return []string{current}
+	}
+
+	return []string{current, previous}
+}
+
+// Settings are the effective value of every setting read and where it came from, in the order they were read
+func (c *AppConfig) Settings() []Setting {
+	return c.settings
 }
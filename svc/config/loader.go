@@ -0,0 +1,263 @@
+package config
+
+import (
+	"buildkansen/secrets"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SourceDefault = "default"
+	SourceFile    = "config file"
+	SourceEnv     = "env"
+	SourceEnvFile = "env file"
+)
+
+// Setting is where a setting's effective value came from
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+	Secret bool
+}
+
+// loader reads the settings and collects what is wrong with them instead of stopping at the first
+type loader struct {
+	file     map[string]interface{}
+	provider secrets.Provider
+	settings []Setting
+	errs     []string
+}
+
+// newLoader reads the config file at path. A missing file is only an error when it was asked for
+func newLoader(path string, required bool) *loader {
+	l := &loader{file: make(map[string]interface{})}
+
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return l
+	}
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("could not read the config file %s: %s", path, err))
+		return l
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(contents)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&l.file); err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("could not parse the config file %s: %s", path, err))
+	}
+
+	return l
+}
+
+// lookup finds the key in the environment, then in the file <KEY>_FILE names, then in the config file
+func (l *loader) lookup(key string) (string, string, bool) {
+	if value, exists := os.LookupEnv(key); exists {
+		return value, SourceEnv, true
+	}
+
+	if path, exists := os.LookupEnv(key + "_FILE"); exists {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Sprintf("%s_FILE: %s", key, err))
+			return "", SourceEnvFile, false
+		}
+		return strings.TrimSpace(string(contents)), SourceEnvFile, true
+	}
+
+	value, exists := l.file[strings.ToLower(key)]
+	if !exists || value == nil {
+		return "", "", false
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ","), SourceFile, true
+	default:
+		return fmt.Sprint(v), SourceFile, true
+	}
+}
+
+func (l *loader) read(key string, defaultValue string, secret bool) string {
+	value, source, found := l.lookup(key)
+	if !found || len(value) == 0 {
+		value, source = defaultValue, SourceDefault
+	}
+
+	l.settings = append(l.settings, Setting{Key: key, Value: value, Source: source, Secret: secret})
+	return value
+}
+
+func (l *loader) string(key string, defaultValue string) string {
+	return l.read(key, defaultValue, false)
+}
+
+// required is a setting the service can't run without
+func (l *loader) required(key string, secret bool) string {
+	value := l.read(key, "", secret)
+	if len(value) == 0 {
+		l.errs = append(l.errs, fmt.Sprintf("%s is required", key))
+	}
+
+	return value
+}
+
+// int64 is a number no less than min
+func (l *loader) int64(key string, defaultValue int64, min int64) int64 {
+	valueStr := l.read(key, strconv.FormatInt(defaultValue, 10), false)
+	value, err := strconv.ParseInt(valueStr, 10, 64)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("%s is not a number: %q", key, valueStr))
+		return defaultValue
+	}
+	if value < min {
+		l.errs = append(l.errs, fmt.Sprintf("%s must be at least %d, it is %d", key, min, value))
+	}
+
+	return value
+}
+
+func (l *loader) bool(key string, defaultValue bool) bool {
+	valueStr := l.read(key, strconv.FormatBool(defaultValue), false)
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("%s is not true or false: %q", key, valueStr))
+		return defaultValue
+	}
+
+	return value
+}
+
+// int64List is a comma-separated list of numbers
+func (l *loader) int64List(key string) []int64 {
+	values := make([]int64, 0)
+	for _, valueStr := range strings.Split(l.read(key, "", false), ",") {
+		valueStr = strings.TrimSpace(valueStr)
+		if len(valueStr) == 0 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(valueStr, 10, 64)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Sprintf("%s has %q, which is not a number", key, valueStr))
+			continue
+		}
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// secretsProvider is the provider SECRETS_PROVIDER picks, the secrets are read through it from then on
+func (l *loader) secretsProvider() secrets.Provider {
+	switch name := l.string("SECRETS_PROVIDER", secrets.ProviderEnv); name {
+	case secrets.ProviderEnv:
+		return secrets.Env{}
+	case secrets.ProviderFile:
+		l.provider = secrets.Files{Dir: l.required("SECRETS_DIR", false)}
+	case secrets.ProviderKeystore:
+		if keystore := l.keystore(); keystore != nil {
+			l.provider = keystore
+		}
+	default:
+		l.errs = append(l.errs, fmt.Sprintf("SECRETS_PROVIDER is env, file or keystore, not %q", name))
+	}
+
+	if l.provider == nil {
+		return secrets.Env{}
+	}
+	return l.provider
+}
+
+// keystore opens the keystore SECRETS_KEYSTORE names with the key in SECRETS_KEY
+func (l *loader) keystore() *secrets.Keystore {
+	path := l.required("SECRETS_KEYSTORE", false)
+	key := l.required("SECRETS_KEY", true)
+	if len(path) == 0 || len(key) == 0 {
+		return nil
+	}
+
+	keystore, err := secrets.OpenKeystore(path, key)
+	if err != nil {
+		l.errs = append(l.errs, err.Error())
+		return nil
+	}
+
+	return keystore
+}
+
+// secret reads the setting from the secrets provider, or from the environment
+func (l *loader) secret(key string, required bool) string {
+	if l.provider == nil {
+		if required {
+			return l.required(key, true)
+		}
+		return l.read(key, "", true)
+	}
+
+	return l.fromProvider(key, required, true)
+}
+
+func (l *loader) fromProvider(key string, required bool, secret bool) string {
+	value, found, err := l.provider.Lookup(key)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("could not read %s from the %s secrets: %s", key, l.provider.Name(), err))
+	}
+
+	source := l.provider.Name()
+	if !found {
+		source = SourceDefault
+	}
+	l.settings = append(l.settings, Setting{Key: key, Value: value, Source: source, Secret: secret})
+
+	if required && len(value) == 0 {
+		l.errs = append(l.errs, fmt.Sprintf("%s is required", key))
+	}
+	return value
+}
+
+// until is when a rotated secret's overlap ends, as RFC 3339. It is kept with the secret it belongs to
+func (l *loader) until(key string) time.Time {
+	var valueStr string
+	if l.provider == nil {
+		valueStr = l.read(key, "", false)
+	} else {
+		valueStr = l.fromProvider(key, false, false)
+	}
+	if len(valueStr) == 0 {
+		return time.Time{}
+	}
+
+	value, err := time.Parse(time.RFC3339, valueStr)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Sprintf("%s is not an RFC 3339 time: %q", key, valueStr))
+	}
+
+	return value
+}
+
+// err lists everything that is wrong, one per line, it is nil when nothing is
+func (l *loader) err() error {
+	if len(l.errs) == 0 {
+		return nil
+	}
+
+	return errors.New("  - " + strings.Join(l.errs, "\n  - "))
+}
+
+// check records the problem when ok is false
+func (l *loader) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		l.errs = append(l.errs, fmt.Sprintf(format, args...))
+	}
+}
@@ -7,12 +7,20 @@ import (
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/google/go-github/v57/github"
 	"net/http"
+	"net/url"
 )
 
 type ClientApi interface {
 	GetInstallation(context.Context, int64) (*github.Installation, *github.Response, error)
 	GetInstallationRepos(context.Context) (*github.ListRepositories, *github.Response, error)
 	GetActionsRegistrationToken(context.Context, string, string) (*github.RegistrationToken, *github.Response, error)
+	ListRunners(context.Context, string, string) ([]*github.Runner, error)
+	RemoveRunner(context.Context, string, string, int64) (*github.Response, error)
+	CancelWorkflowRun(context.Context, string, string, int64) (*github.Response, error)
+	GetWorkflowJobLogs(context.Context, string, string, int64) (*url.URL, *github.Response, error)
+	CreateCheckRun(context.Context, string, string, github.CreateCheckRunOptions) (*github.CheckRun, *github.Response, error)
+	UpdateCheckRun(context.Context, string, string, int64, github.UpdateCheckRunOptions) (*github.CheckRun, *github.Response, error)
+	GetOrgMembership(context.Context, string, string) (*github.Membership, *github.Response, error)
 }
 
 // Client implements ClientApi interface
@@ -55,3 +63,79 @@ func (cl Client) GetInstallationRepos() (*github.ListRepositories, *github.Respo
 func (cl Client) GetActionsRegistrationToken(owner string, repo string) (*github.RegistrationToken, *github.Response, error) {
 	return cl.REG.Actions.CreateRegistrationToken(context.Background(), owner, repo)
 }
+
+func (cl Client) ListRunners(owner string, repo string) ([]*github.Runner, error) {
+	runners := make([]*github.Runner, 0)
+	opts := &github.ListOptions{PerPage: 100}
+
+	for {
+		page, response, err := cl.REG.Actions.ListRunners(context.Background(), owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		runners = append(runners, page.Runners...)
+		if response.NextPage == 0 {
+			break
+		}
+		opts.Page = response.NextPage
+	}
+
+	return runners, nil
+}
+
+func (cl Client) RemoveRunner(owner string, repo string, runnerId int64) (*github.Response, error) {
+	return cl.REG.Actions.RemoveRunner(context.Background(), owner, repo, runnerId)
+}
+
+func (cl Client) CancelWorkflowRun(owner string, repo string, runId int64) (*github.Response, error) {
+	return cl.REG.Actions.CancelWorkflowRunByID(context.Background(), owner, repo, runId)
+}
+
+func (cl Client) CreateCheckRun(owner string, repo string, opts github.CreateCheckRunOptions) (*github.CheckRun, *github.Response, error) {
+	return cl.REG.Checks.CreateCheckRun(context.Background(), owner, repo, opts)
+}
+
+func (cl Client) UpdateCheckRun(owner string, repo string, checkRunId int64, opts github.UpdateCheckRunOptions) (*github.CheckRun, *github.Response, error) {
+	return cl.REG.Checks.UpdateCheckRun(context.Background(), owner, repo, checkRunId, opts)
+}
+
+// GetWorkflowJobLogs returns a short-lived URL to download the job's logs from
+func (cl Client) GetWorkflowJobLogs(owner string, repo string, jobId int64) (*url.URL, *github.Response, error) {
+	return cl.REG.Actions.GetWorkflowJobLogs(context.Background(), owner, repo, jobId, 1)
+}
+
+// GetOrgMembership needs the app's organization members permission, it 404s when the user isn't a member
+func (cl Client) GetOrgMembership(org string, user string) (*github.Membership, *github.Response, error) {
+	return cl.REG.Organizations.GetOrgMembership(context.Background(), user, org)
+}
+
+// UserClient acts as a signed in user, with the OAuth token they signed in with
+type UserClient struct {
+	REG *github.Client
+}
+
+func NewUserClient(token string) *UserClient {
+	return &UserClient{REG: github.NewClient(nil).WithAuthToken(token)}
+}
+
+// ListOrganizations lists the organizations the user is a member of, private memberships need the read:org scope
+func (cl UserClient) ListOrganizations() ([]*github.Organization, error) {
+	organizations := make([]*github.Organization, 0)
+	opts := &github.ListOptions{PerPage: 100}
+
+	for {
+		page, response, err := cl.REG.Organizations.List(context.Background(), "", opts)
+		if err != nil {
+			return nil, err
+		}
+
+		organizations = append(organizations, page...)
+		if response.NextPage == 0 {
+			break
+		}
+		opts.Page = response.NextPage
+	}
+
+	return organizations, nil
+}
@@ -0,0 +1,236 @@
+package checks
+
+import (
+	"buildkansen/config"
+	githubApi "buildkansen/github"
+	"buildkansen/models"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
+
+const checkRunName = "Buildkansen runner"
+
+const (
+	statusQueued     = "queued"
+	statusInProgress = "in_progress"
+	statusCompleted  = "completed"
+)
+
+type checkRun struct {
+	client *githubApi.Client
+	jobRun *models.WorkflowJobRun
+}
+
+// Queued creates the check run for a job that is waiting for a VM
+func Queued(jobId int64, repositoryInternalId int64) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	position, err := models.WorkflowJobRunQueuePosition(cr.jobRun)
+	if err != nil {
+		fmt.Println("could not compute queue position: ", err)
+	}
+
+	summary := fmt.Sprintf("Waiting for a VM labelled `%s`. Position in queue: **%d**.", cr.jobRun.Labels, position)
+	cr.create(statusQueued, "", "Queued", summary, "")
+}
+
+// Delayed keeps the check run queued but explains why the job is not being picked up
+func Delayed(jobId int64, repositoryInternalId int64, reason string) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	cr.update(statusQueued, "", "Waiting for capacity", fmt.Sprintf("Still waiting for a VM labelled `%s`.", cr.jobRun.Labels), reason)
+}
+
+// Booting marks the check run in progress once a VM has been assigned to the job
+func Booting(jobId int64, repositoryInternalId int64, vm *models.VM) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	summary := fmt.Sprintf("Booting `%s` on host `%s` as `%s`.", vm.GithubRunnerLabel, vm.Host, vm.VMInstanceName)
+	cr.update(statusInProgress, "", "Booting VM", summary, "")
+}
+
+// Booted records that the runner on the guest is configured and waiting for GitHub to hand it the job
+func Booted(jobId int64, repositoryInternalId int64, vm *models.VM) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	summary := fmt.Sprintf("Runner `%s` is up on host `%s`, waiting for GitHub to dispatch the job.", vm.VMInstanceName, vm.Host)
+	cr.update(statusInProgress, "", "VM ready", summary, "")
+}
+
+// Started records that the runner has picked up the job
+func Started(jobId int64, repositoryInternalId int64) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	summary := fmt.Sprintf("Running on `%s` (host `%s`).", cr.jobRun.VMInstanceName, cr.jobRun.VMHost)
+	cr.update(statusInProgress, "", "Running", summary, "")
+}
+
+// Completed closes the check run with the conclusion of the job
+func Completed(jobId int64, repositoryInternalId int64, conclusion string) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	if len(conclusion) == 0 {
+		conclusion = "neutral"
+	}
+
+	summary := fmt.Sprintf("Ran on `%s` (host `%s`), VM has been torn down.", cr.jobRun.VMInstanceName, cr.jobRun.VMHost)
+	cr.update(statusCompleted, conclusion, "Completed", summary, "")
+}
+
+// Failed closes the check run with the reason our infrastructure could not run the job
+func Failed(jobId int64, repositoryInternalId int64, reason string) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	cr.update(statusCompleted, "failure", "Infrastructure failure", "Buildkansen could not run this job.", reason)
+}
+
+// Cancelled closes the check run of a job that was taken off the queue before it got a VM
+func Cancelled(jobId int64, repositoryInternalId int64) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	cr.update(statusCompleted, "cancelled", "Cancelled", "The job was cancelled while it was waiting for a VM.", "")
+}
+
+// TimedOut closes the check run of a job we stopped for running past its maximum runtime
+func TimedOut(jobId int64, repositoryInternalId int64, reason string) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	cr.update(statusCompleted, models.TimedOutConclusion, "Timed out", "Buildkansen stopped this job and tore down its VM.", reason)
+}
+
+// Refused creates the check run already closed for a job we will not run, asking someone to act on the reason
+func Refused(jobId int64, repositoryInternalId int64, reason string) {
+	cr := load(jobId, repositoryInternalId)
+	if cr == nil {
+		return
+	}
+
+	cr.create(statusCompleted, "action_required", "Minute budget spent", "Buildkansen did not run this job.", reason)
+}
+
+func load(jobId int64, repositoryInternalId int64) *checkRun {
+	if !config.C.GithubChecksEnabled {
+		return nil
+	}
+
+	jobRun, err := models.FindWorkflowJobRun(jobId, repositoryInternalId)
+	if err != nil {
+		fmt.Printf("could not find workflow job run %d for check run: %s\n", jobId, err)
+		return nil
+	}
+
+	if len(jobRun.HeadSha) == 0 {
+		return nil
+	}
+
+	client, err := githubApi.NewClient(config.C.GithubAppId, jobRun.Repository.Installation.Id, config.C.GithubPrivateKeyBase64)
+	if err != nil {
+		fmt.Println("could not create a client for check run: ", err)
+		return nil
+	}
+
+	return &checkRun{client: client, jobRun: jobRun}
+}
+
+func (cr *checkRun) create(status string, conclusion string, title string, summary string, text string) {
+	opts := github.CreateCheckRunOptions{
+		Name:       checkRunName,
+		HeadSHA:    cr.jobRun.HeadSha,
+		DetailsURL: github.String(DetailsUrl(cr.jobRun)),
+		ExternalID: github.String(strconv.FormatInt(cr.jobRun.Id, 10)),
+		Status:     github.String(status),
+		StartedAt:  &github.Timestamp{Time: cr.jobRun.StartedAt},
+		Output:     output(title, summary, text),
+	}
+
+	if status == statusCompleted {
+		opts.Conclusion = github.String(conclusion)
+		opts.CompletedAt = &github.Timestamp{Time: time.Now()}
+	}
+
+	checkRun, _, err := cr.client.CreateCheckRun(cr.owner(), cr.jobRun.Repository.Name, opts)
+	if err != nil {
+		fmt.Printf("could not create check run for job %d: %s\n", cr.jobRun.Id, err)
+		return
+	}
+
+	result := models.SetWorkflowJobRunCheckRun(cr.jobRun.Id, cr.jobRun.RepositoryId, checkRun.GetID())
+	if result.Error != nil {
+		fmt.Printf("could not save check run for job %d: %s\n", cr.jobRun.Id, result.Error)
+	}
+}
+
+func (cr *checkRun) update(status string, conclusion string, title string, summary string, text string) {
+	if !cr.jobRun.CheckRunId.Valid {
+		return
+	}
+
+	opts := github.UpdateCheckRunOptions{
+		Name:       checkRunName,
+		DetailsURL: github.String(DetailsUrl(cr.jobRun)),
+		Status:     github.String(status),
+		Output:     output(title, summary, text),
+	}
+
+	if status == statusCompleted {
+		opts.Conclusion = github.String(conclusion)
+		opts.CompletedAt = &github.Timestamp{Time: time.Now()}
+	}
+
+	_, _, err := cr.client.UpdateCheckRun(cr.owner(), cr.jobRun.Repository.Name, cr.jobRun.CheckRunId.Int64, opts)
+	if err != nil {
+		fmt.Printf("could not update check run for job %d: %s\n", cr.jobRun.Id, err)
+	}
+}
+
+func (cr *checkRun) owner() string {
+	return cr.jobRun.Repository.Installation.AccountLogin
+}
+
+// DetailsUrl links to the job on the dashboard
+func DetailsUrl(jobRun *models.WorkflowJobRun) string {
+	return fmt.Sprintf("%s/runs/%d", config.C.AppUrl, jobRun.InternalId)
+}
+
+func output(title string, summary string, text string) *github.CheckRunOutput {
+	o := &github.CheckRunOutput{
+		Title:   github.String(title),
+		Summary: github.String(summary),
+	}
+
+	if len(text) > 0 {
+		o.Text = github.String(text)
+	}
+
+	return o
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"buildkansen/config"
+	"buildkansen/db"
+	"buildkansen/internal/app_error"
+	"buildkansen/internal/checks"
+	"buildkansen/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// IsAdmin is true for the operators listed in ADMIN_USER_IDS
+func IsAdmin(user *models.User) bool {
+	return slices.Contains(config.C.AdminUserIds, user.Id)
+}
+
+func SetHostDrained(host string, drained bool) *app_error.AppError {
+	result := models.SetHostDrained(host, drained)
+	if result.Error != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to update the host", result.Error)
+	}
+
+	PublishPoolChange()
+	return nil
+}
+
+// ForceFreeVM returns the VM to the pool without tearing its guest down, for slots stuck after the guest is gone
+func ForceFreeVM(vm *models.VM) *app_error.AppError {
+	fmt.Printf("force freeing VM %d (%s)\n", vm.Id, vm.VMInstanceName)
+	result := models.FreeVM(vm)
+	if result.Error != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to free the VM", result.Error)
+	}
+
+	PublishPoolChange()
+	return nil
+}
+
+// RequeueJob puts a job that never started on its runner back in the queue and tears down its VM
+func RequeueJob(jobRun *models.WorkflowJobRun) *app_error.AppError {
+	errStarted := errors.New("job has started")
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := models.RequeueWorkflowJobRun(tx, jobRun.Id, jobRun.RepositoryId)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errStarted
+		}
+
+		if len(jobRun.VMInstanceName) == 0 {
+			return nil
+		}
+		vm, err := models.FindVMByInstanceName(jobRun.VMInstanceName)
+		if err != nil {
+			return nil
+		}
+		return models.RequestVMTeardown(tx, vm).Error
+	})
+	if errors.Is(err, errStarted) {
+		return app_error.NewAppError(http.StatusConflict, "Only jobs that have not started can be requeued", err)
+	}
+	if err != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to requeue the job", err)
+	}
+
+	PublishPoolChange()
+	PublishRunChange(jobRun.Id, jobRun.RepositoryId)
+	checks.Queued(jobRun.Id, jobRun.RepositoryId)
+	return nil
+}
+
+// CancelJob cancels any job that has not ended, the run must have its repository and installation loaded
+func CancelJob(jobRun *models.WorkflowJobRun) *app_error.AppError {
+	if jobRun.Pending() {
+		return CancelPendingJob(jobRun)
+	}
+	if jobRun.EndedAt.Valid {
+		return app_error.NewAppError(http.StatusConflict, "The job has already ended", errors.New("job has ended"))
+	}
+
+	return CancelWorkflowRun(&jobRun.Repository, jobRun.WorkflowRunId)
+}
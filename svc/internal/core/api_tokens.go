@@ -0,0 +1,100 @@
+package core
+
+import (
+	"buildkansen/internal/app_error"
+	"buildkansen/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	apiTokenPrefix       = "bk_"
+	apiTokenBytes        = 24
+	apiTokenShownPrefix  = 10
+	MaxApiTokenValidDays = 365
+)
+
+// IssueApiToken creates a token for the user and returns it in the clear, the only time it is available
+func IssueApiToken(user *models.User, name string, scopes []string, validDays int) (string, *models.ApiToken, *app_error.AppError) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return "", nil, app_error.NewAppError(http.StatusUnprocessableEntity, "The token needs a name", errors.New("empty token name"))
+	}
+
+	if len(scopes) == 0 {
+		return "", nil, app_error.NewAppError(http.StatusUnprocessableEntity, "The token needs at least one scope", errors.New("no scopes"))
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return "", nil, app_error.NewAppError(http.StatusUnprocessableEntity, "Unknown scope "+scope, fmt.Errorf("unknown scope %s", scope))
+		}
+	}
+
+	if validDays < 1 || validDays > MaxApiTokenValidDays {
+		return "", nil, app_error.NewAppError(http.StatusUnprocessableEntity, "Tokens are valid for 1 to 365 days", fmt.Errorf("invalid validity %d", validDays))
+	}
+
+	secret := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to generate a token", err)
+	}
+	plain := apiTokenPrefix + hex.EncodeToString(secret)
+
+	token := models.ApiToken{
+		UserId:    user.Id,
+		Name:      name,
+		Prefix:    plain[:apiTokenShownPrefix],
+		TokenHash: HashApiToken(plain),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: sql.NullTime{Time: time.Now().AddDate(0, 0, validDays), Valid: true},
+	}
+
+	result := models.CreateApiToken(&token)
+	if result.Error != nil {
+		return "", nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to save the token", result.Error)
+	}
+
+	return plain, &token, nil
+}
+
+// AuthenticateApiToken returns the active token matching plain, nil if there is none
+func AuthenticateApiToken(plain string) *models.ApiToken {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return nil
+	}
+
+	token, err := models.FindApiToken(HashApiToken(plain))
+	if err != nil || !token.Active() {
+		return nil
+	}
+
+	result := models.TouchApiToken(token)
+	if result.Error != nil {
+		fmt.Printf("could not record use of api token %d: %s\n", token.Id, result.Error)
+	}
+
+	return token
+}
+
+// HashApiToken is how tokens are stored, they are random enough that an unsalted digest is safe
+func HashApiToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func validScope(scope string) bool {
+	for _, s := range models.ApiScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
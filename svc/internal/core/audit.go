@@ -0,0 +1,106 @@
+package core
+
+import (
+	"buildkansen/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// AuditTarget is what an audited action was done to
+type AuditTarget struct {
+	Type string
+	Id   string
+	// InstallationId is the internal id of the installation the target belongs to, 0 if none
+	InstallationId int64
+}
+
+func InstallationTarget(installation *models.Installation) AuditTarget {
+	return AuditTarget{Type: "installation", Id: strconv.FormatInt(installation.Id, 10), InstallationId: installation.InternalId}
+}
+
+func RepositoryTarget(repository *models.Repository) AuditTarget {
+	return AuditTarget{Type: "repository", Id: strconv.FormatInt(repository.Id, 10), InstallationId: repository.InstallationId}
+}
+
+func RunTarget(jobRun *models.WorkflowJobRun) AuditTarget {
+	return AuditTarget{Type: "run", Id: strconv.FormatInt(jobRun.Id, 10), InstallationId: jobRun.Repository.InstallationId}
+}
+
+func UserTarget(userId int64) AuditTarget {
+	return AuditTarget{Type: "user", Id: strconv.FormatInt(userId, 10)}
+}
+
+func VMTarget(vm *models.VM) AuditTarget {
+	return AuditTarget{Type: "vm", Id: strconv.FormatInt(vm.Id, 10)}
+}
+
+func HostTarget(host string) AuditTarget {
+	return AuditTarget{Type: "host", Id: host}
+}
+
+func LabelTarget(label string) AuditTarget {
+	return AuditTarget{Type: "label", Id: label}
+}
+
+func ImageTarget(image *models.Image) AuditTarget {
+	return AuditTarget{Type: "image", Id: image.Name + ":" + image.Version}
+}
+
+func ApiTokenTarget(tokenId int64) AuditTarget {
+	return AuditTarget{Type: "api_token", Id: strconv.FormatInt(tokenId, 10)}
+}
+
+// VMSnapshot is what the audit log keeps of a VM that was bound, unbound, freed or purged
+func VMSnapshot(vm *models.VM) map[string]interface{} {
+	return map[string]interface{}{
+		"base_vm_name":        vm.BaseVMName,
+		"github_runner_label": vm.GithubRunnerLabel,
+		"host":                vm.Host,
+		"image_id":            vm.ImageId.Int64,
+		"status":              vm.Status,
+		"vm_instance_name":    vm.VMInstanceName,
+	}
+}
+
+// RunSnapshot is what the audit log keeps of a job that was cancelled or requeued
+func RunSnapshot(jobRun *models.WorkflowJobRun) map[string]interface{} {
+	return map[string]interface{}{
+		"repository":       jobRun.Repository.FullName,
+		"workflow_run_id":  jobRun.WorkflowRunId,
+		"name":             jobRun.Name,
+		"labels":           jobRun.Labels,
+		"vm_instance_name": jobRun.VMInstanceName,
+		"started_at":       jobRun.StartedAt,
+	}
+}
+
+// RecordAudit saves the event against the target, failing to record it is logged rather than returned
+func RecordAudit(event *models.AuditEvent, target AuditTarget, before interface{}, after interface{}) {
+	event.TargetType = target.Type
+	event.TargetId = target.Id
+	if target.InstallationId != 0 {
+		event.InstallationId = sql.NullInt64{Int64: target.InstallationId, Valid: true}
+	}
+	event.Before = auditValue(before)
+	event.After = auditValue(after)
+
+	if err := models.RecordAuditEvent(event); err != nil {
+		fmt.Printf("could not record audit event %s on %s %s: %s\n", event.Action, target.Type, target.Id, err)
+	}
+}
+
+func auditValue(value interface{}) sql.NullString {
+	if value == nil {
+		return sql.NullString{}
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		fmt.Println("could not encode audit value: ", err)
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: string(encoded), Valid: true}
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"buildkansen/config"
+	"buildkansen/internal/checks"
+	"buildkansen/models"
+	"fmt"
+	"time"
+)
+
+const budgetNotificationKind = "budget"
+
+// CheckBudget returns why a new job of the installation has to be refused, or an empty string if it may run
+func CheckBudget(installation *models.Installation) string {
+	if installation.MonthlyMinuteBudget <= 0 {
+		return ""
+	}
+
+	now := time.Now().UTC()
+	used, err := models.BillableMinutesUsed(installation.InternalId, now)
+	if err != nil {
+		fmt.Printf("could not sum usage for installation %d, not enforcing its budget: %s\n", installation.Id, err)
+		return ""
+	}
+
+	month := now.Format(usageMonthFormat)
+
+	if used >= installation.MonthlyMinuteBudget {
+		reason := fmt.Sprintf("%s has used %d of its %d minutes for %s. Jobs will run again next month or once the budget is raised.",
+			installation.AccountLogin, used, installation.MonthlyMinuteBudget, month)
+
+		if installation.BudgetExhaustedFor != month {
+			notifyBudget(installation, reason)
+			models.MarkInstallationBudgetExhausted(installation, month)
+		}
+
+		return reason
+	}
+
+	softLimit := installation.MonthlyMinuteBudget * int64(installation.SoftLimitPercent) / 100
+	if used >= softLimit && installation.BudgetWarnedFor != month {
+		notifyBudget(installation, fmt.Sprintf("%s has used %d of its %d minutes for %s.",
+			installation.AccountLogin, used, installation.MonthlyMinuteBudget, month))
+		models.MarkInstallationBudgetWarned(installation, month)
+	}
+
+	return ""
+}
+
+// RefuseWorkflow cancels the workflow run of a job refused for being over budget, closing its check run first
+func RefuseWorkflow(jobId int64, repoId int64, reason string) {
+	jobRun, err := models.FindWorkflowJobRun(jobId, repoId)
+	if err != nil {
+		fmt.Printf("could not find refused workflow job run %d: %s\n", jobId, err)
+		return
+	}
+
+	annotated := false
+	if jobRun.Repository.Installation.BudgetPolicy != models.BudgetPolicyCancel && config.C.GithubChecksEnabled {
+		checks.Refused(jobId, repoId, reason)
+		annotated = true
+	}
+
+	appError := CancelWorkflowRun(&jobRun.Repository, jobRun.WorkflowRunId)
+	if appError == nil {
+		return
+	}
+
+	fmt.Printf("could not cancel refused workflow run %d: %s\n", jobRun.WorkflowRunId, appError.Message)
+	if !annotated {
+		checks.Refused(jobId, repoId, reason)
+	}
+}
+
+// MinutesUsed returns the billable minutes used this month by each installation that has a budget, by internal id
+func MinutesUsed(installations []models.Installation) map[int64]int64 {
+	used := make(map[int64]int64)
+	now := time.Now().UTC()
+
+	for _, installation := range installations {
+		if installation.MonthlyMinuteBudget <= 0 {
+			continue
+		}
+
+		minutes, err := models.BillableMinutesUsed(installation.InternalId, now)
+		if err != nil {
+			fmt.Printf("could not sum usage for installation %d: %s\n", installation.Id, err)
+			continue
+		}
+		used[installation.InternalId] = minutes
+	}
+
+	return used
+}
+
+func notifyBudget(installation *models.Installation, message string) {
+	result := models.CreateNotification(installation.InternalId, budgetNotificationKind, message)
+	if result.Error != nil {
+		fmt.Printf("could not notify installation %d: %s\n", installation.Id, result.Error)
+	}
+}
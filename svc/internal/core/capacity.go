@@ -0,0 +1,86 @@
+package core
+
+import (
+	"buildkansen/config"
+	"buildkansen/internal/checks"
+	"buildkansen/internal/labels"
+	"buildkansen/models"
+	"fmt"
+	"time"
+)
+
+const capacityNotificationKind = "capacity"
+
+// EscalateStalledJobs applies the capacity policy to queued jobs no VM can take or that waited past the SLA
+func EscalateStalledJobs() {
+	jobRuns, err := models.PendingWorkflowJobRuns()
+	if err != nil {
+		fmt.Println("could not fetch the job queue: ", err)
+		return
+	}
+
+	vms, err := models.FetchVMs()
+	if err != nil {
+		fmt.Println("could not fetch the VM pool: ", err)
+		return
+	}
+
+	sla := time.Duration(config.C.QueueSlaMinutes) * time.Minute
+
+	for _, jobRun := range jobRuns {
+		if jobRun.EscalatedAt.Valid {
+			continue
+		}
+
+		var reason string
+		if !hasCapacity(vms, labels.Parse(jobRun.Labels)) {
+			reason = fmt.Sprintf("No usable VMs are configured with all of the labels %s.", jobRun.Labels)
+		} else if time.Since(jobRun.StartedAt) > sla {
+			reason = fmt.Sprintf("The job has been queued for more than %d minutes.", config.C.QueueSlaMinutes)
+		} else {
+			continue
+		}
+
+		escalate(&jobRun, reason)
+	}
+}
+
+// hasCapacity is true when any bound VM, busy or not, could run a job with jobLabels
+func hasCapacity(vms []models.VM, jobLabels []string) bool {
+	for _, vm := range vms {
+		if labels.Satisfies(vm.Labels(), jobLabels) && vm.Usable() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func escalate(jobRun *models.WorkflowJobRun, reason string) {
+	fmt.Printf("escalating workflow job run %d: %s\n", jobRun.Id, reason)
+	result := models.EscalateWorkflowJobRun(jobRun.Id, jobRun.RepositoryId)
+	if result.Error != nil {
+		fmt.Printf("could not escalate workflow job run %d: %s\n", jobRun.Id, result.Error)
+		return
+	}
+
+	installation := jobRun.Repository.Installation
+
+	if installation.CapacityPolicy == models.CapacityPolicyCancel {
+		appError := CancelWorkflowRun(&jobRun.Repository, jobRun.WorkflowRunId)
+		if appError == nil {
+			FailWorkflow(jobRun.Id, jobRun.RepositoryId, reason+" The workflow run was cancelled.")
+			return
+		}
+
+		fmt.Printf("could not cancel workflow run %d, notifying instead: %s\n", jobRun.WorkflowRunId, appError.Message)
+	}
+
+	message := fmt.Sprintf("%s / %s in %s is waiting for a runner. %s", jobRun.WorkflowName, jobRun.Name, jobRun.Repository.FullName, reason)
+	result = models.CreateNotification(installation.InternalId, capacityNotificationKind, message)
+	if result.Error != nil {
+		fmt.Printf("could not notify installation %d: %s\n", installation.Id, result.Error)
+	}
+
+	checks.Delayed(jobRun.Id, jobRun.RepositoryId, reason)
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"buildkansen/internal/events"
+	"buildkansen/models"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RunChange is what a dashboard needs to redraw a run's row
+type RunChange struct {
+	InternalId    int64      `json:"internal_id"`
+	Id            int64      `json:"id"`
+	Name          string     `json:"name"`
+	WorkflowName  string     `json:"workflow_name"`
+	WorkflowRunId int64      `json:"workflow_run_id"`
+	Repository    string     `json:"repository"`
+	Status        string     `json:"status"`
+	FailureReason string     `json:"failure_reason,omitempty"`
+	VMHost        string     `json:"vm_host,omitempty"`
+	StartedAt     time.Time  `json:"started_at"`
+	ProcessingAt  *time.Time `json:"processing_at"`
+	EndedAt       *time.Time `json:"ended_at"`
+}
+
+// PublishRunChange pushes the run's current state to the dashboards of its installation
+func PublishRunChange(jobId int64, repoId int64) {
+	jobRun, err := models.FindWorkflowJobRun(jobId, repoId)
+	if err != nil {
+		fmt.Printf("could not find workflow job run %d to publish: %s\n", jobId, err)
+		return
+	}
+
+	events.Publish(events.Event{
+		Kind:           events.KindRun,
+		InstallationId: jobRun.Repository.InstallationId,
+		Data: RunChange{
+			InternalId:    jobRun.InternalId,
+			Id:            jobRun.Id,
+			Name:          jobRun.Name,
+			WorkflowName:  jobRun.WorkflowName,
+			WorkflowRunId: jobRun.WorkflowRunId,
+			Repository:    jobRun.Repository.FullName,
+			Status:        jobRun.DisplayStatus(),
+			FailureReason: jobRun.FailureReason.String,
+			VMHost:        jobRun.VMHost,
+			StartedAt:     jobRun.StartedAt,
+			ProcessingAt:  nullTime(jobRun.ProcessingAt),
+			EndedAt:       nullTime(jobRun.EndedAt),
+		},
+	})
+}
+
+// PublishPoolChange pushes the free and busy VM counts per label to every dashboard, the pool is shared
+func PublishPoolChange() {
+	pool, err := models.PoolSummary()
+	if err != nil {
+		fmt.Println("could not summarise the VM pool: ", err)
+		return
+	}
+
+	events.Publish(events.Event{Kind: events.KindPool, Data: pool})
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
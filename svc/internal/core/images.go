@@ -0,0 +1,98 @@
+package core
+
+import (
+	"buildkansen/internal/app_error"
+	"buildkansen/internal/labels"
+	"buildkansen/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const fullRolloutPercent = 100
+
+func PublishImage(image *models.Image) *app_error.AppError {
+	if len(image.Name) == 0 || len(image.Version) == 0 || len(image.LabelSet()) == 0 {
+		return app_error.NewAppError(http.StatusUnprocessableEntity, "An image needs a name, a version and the runner labels its VMs serve", nil)
+	}
+
+	result := models.UpsertImage(image)
+	if result.Error != nil {
+		return app_error.NewAppError(http.StatusUnprocessableEntity, "Failed to publish the image", result.Error)
+	}
+
+	fmt.Printf("published image %s@%s\n", image.Name, image.Version)
+	return nil
+}
+
+// PromoteImage sends percent of label's jobs to the image, at 100 it becomes the label's stable image
+func PromoteImage(label string, imageId int64, percent int) (*models.Rollout, *app_error.AppError) {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if len(labels.Parse(label)) != 1 {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, "A rollout is for exactly one label", nil)
+	}
+
+	if percent <= 0 || percent > fullRolloutPercent {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, "The percentage should be between 1 and 100", nil)
+	}
+
+	_, err := models.FindEntityById(models.Image{}, imageId)
+	if err != nil {
+		return nil, app_error.NewAppError(http.StatusNotFound, "Failed to find the image", err)
+	}
+
+	rollout, err := models.FindRollout(label)
+	if err != nil {
+		return nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to fetch the rollout", err)
+	}
+
+	image := sql.NullInt64{Int64: imageId, Valid: true}
+	if rollout.StableImageId == image {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, "The image is already stable for this label", nil)
+	}
+
+	if percent == fullRolloutPercent {
+		rollout.PreviousImageId = rollout.StableImageId
+		rollout.StableImageId = image
+		rollout.CanaryImageId = sql.NullInt64{}
+		rollout.CanaryPercent = 0
+	} else {
+		rollout.CanaryImageId = image
+		rollout.CanaryPercent = percent
+	}
+
+	result := models.SaveRollout(rollout)
+	if result.Error != nil {
+		return nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to save the rollout", result.Error)
+	}
+
+	fmt.Printf("promoted image %d to %d%% of %s\n", imageId, percent, label)
+	return rollout, nil
+}
+
+// RollbackImage abandons a canary if there is one, otherwise restores the previous stable image
+func RollbackImage(label string) (*models.Rollout, *app_error.AppError) {
+	rollout, err := models.FindRollout(strings.ToLower(strings.TrimSpace(label)))
+	if err != nil {
+		return nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to fetch the rollout", err)
+	}
+
+	if rollout.CanaryImageId.Valid {
+		rollout.CanaryImageId = sql.NullInt64{}
+		rollout.CanaryPercent = 0
+	} else if rollout.PreviousImageId.Valid {
+		rollout.StableImageId, rollout.PreviousImageId = rollout.PreviousImageId, sql.NullInt64{}
+	} else {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, "There is nothing to roll back to", errors.New("no canary or previous image"))
+	}
+
+	result := models.SaveRollout(rollout)
+	if result.Error != nil {
+		return nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to save the rollout", result.Error)
+	}
+
+	fmt.Printf("rolled back %s\n", rollout.Label)
+	return rollout, nil
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"buildkansen/config"
+	"buildkansen/db"
+	githubApi "buildkansen/github"
+	"buildkansen/models"
+	"fmt"
+)
+
+// LinkMemberships links the user to the installations of their account and of the organizations they belong to
+func LinkMemberships(user *models.User, accessToken string) {
+	organizations, listErr := githubApi.NewUserClient(accessToken).ListOrganizations()
+	if listErr != nil {
+		fmt.Printf("could not list the organizations of %s, their memberships are left as they are: %s\n", user.Login, listErr)
+	}
+
+	logins := make([]string, 0, len(organizations))
+	for _, organization := range organizations {
+		logins = append(logins, organization.GetLogin())
+	}
+
+	installations, err := models.FetchAccountInstallations(user.Id, logins)
+	if err != nil {
+		fmt.Println("could not fetch the installations to link: ", err)
+		return
+	}
+
+	memberships, err := models.FetchMemberships(user.Id)
+	if err != nil {
+		fmt.Println("could not fetch the memberships to link: ", err)
+		return
+	}
+	linked := make(map[int64]bool)
+	for _, membership := range memberships {
+		linked[membership.InstallationId] = true
+	}
+
+	kept := make([]int64, 0)
+	for _, installation := range installations {
+		switch installation.AccountType {
+		case "User":
+			addMembership(user, &installation, models.RoleOwner)
+		case "Organization":
+			kept = append(kept, installation.InternalId)
+			// a member keeps their role, only new members need theirs looked up
+			if !linked[installation.InternalId] {
+				linkOrganizationMembership(user, &installation)
+			}
+		}
+	}
+
+	if listErr != nil {
+		return
+	}
+	if result := models.RemoveLinkedOrganizationMemberships(user.Id, kept); result.Error != nil {
+		fmt.Println("could not unlink former organization members: ", result.Error)
+	}
+}
+
+func linkOrganizationMembership(user *models.User, installation *models.Installation) {
+	client, err := githubApi.NewClient(config.C.GithubAppId, installation.Id, config.C.GithubPrivateKeyBase64)
+	if err != nil {
+		fmt.Println("could not create a GitHub client: ", err)
+		return
+	}
+
+	membership, _, err := client.GetOrgMembership(installation.AccountLogin, user.Login)
+	if err != nil {
+		fmt.Printf("could not fetch the membership of %s in %s: %v\n", user.Login, installation.AccountLogin, err)
+		return
+	}
+	if membership.GetState() != "active" {
+		return
+	}
+
+	role := models.RoleViewer
+	if membership.GetRole() == "admin" {
+		role = models.RoleAdmin
+	}
+
+	addMembership(user, installation, role)
+}
+
+func addMembership(user *models.User, installation *models.Installation, role models.Role) {
+	if result := models.AddMembership(db.DB, user.Id, installation.InternalId, role); result.Error != nil {
+		fmt.Printf("could not link %s to %s: %v\n", user.Login, installation.AccountLogin, result.Error)
+	}
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"buildkansen/models"
+	"time"
+)
+
+// reconcileGrace is how long a VM or run must have been out of step before reconcile acts on it
+const reconcileGrace = 10 * time.Minute
+
+// Reconciliation is what reconcile found out of step between the pool and the runs
+type Reconciliation struct {
+	// OrphanedVMs are held for runs that have ended or are gone, they are left to their host's scheduler
+	OrphanedVMs []models.VM
+	// LostRuns lost their VM without GitHub reporting them completed, they are failed
+	LostRuns []models.WorkflowJobRun
+	// FailedRuns are the ones that were put right, a dry run has none
+	FailedRuns []models.WorkflowJobRun
+}
+
+// Reconcile finds the VMs and runs that are out of step and, unless it is a dry run, fails the runs
+func Reconcile(dryRun bool) (*Reconciliation, error) {
+	cutoff := time.Now().Add(-reconcileGrace)
+	orphaned, err := models.OrphanedVMs(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	lost, err := models.LostWorkflowJobRuns(cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	reconciliation := &Reconciliation{
+		OrphanedVMs: orphaned,
+		LostRuns:    lost,
+		FailedRuns:  make([]models.WorkflowJobRun, 0),
+	}
+	if dryRun {
+		return reconciliation, nil
+	}
+
+	for _, jobRun := range lost {
+		FailWorkflow(jobRun.Id, jobRun.RepositoryId, "The VM running the job was lost")
+		reconciliation.FailedRuns = append(reconciliation.FailedRuns, jobRun)
+	}
+	SweepStaleRunners()
+
+	return reconciliation, nil
+}
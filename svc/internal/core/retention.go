@@ -0,0 +1,92 @@
+package core
+
+import (
+	"buildkansen/config"
+	"buildkansen/db"
+	"buildkansen/internal/app_error"
+	"buildkansen/models"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// DeletionGraceStart is the earliest a deletion can have happened and still be restored
+func DeletionGraceStart() time.Time {
+	return time.Now().AddDate(0, 0, -int(config.C.DeletionGraceDays))
+}
+
+// RestoreAccount brings back the user's account when they sign in again during the grace period
+func RestoreAccount(userId int64) bool {
+	restored, err := models.RestoreUser(userId, DeletionGraceStart())
+	if err != nil {
+		fmt.Printf("could not restore the account of user %d: %s\n", userId, err)
+		return false
+	}
+
+	return restored
+}
+
+func RestoreInstallation(user *models.User, internalId int64) (*models.Installation, *app_error.AppError) {
+	result := models.RestoreInstallation(user.Id, internalId, DeletionGraceStart())
+	if result.Error != nil {
+		return nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to restore the installation", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, app_error.NewAppError(http.StatusNotFound, "Installation not found", errors.New("no deleted installation to restore"))
+	}
+
+	installation, err := models.FindEntity(models.Installation{}, internalId, "internal_id")
+	if err != nil {
+		return nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to restore the installation", err)
+	}
+
+	restored := installation.(models.Installation)
+	return &restored, nil
+}
+
+// RestoreAdministeredInstallation brings back a deleted installation for an administrator of its account
+func RestoreAdministeredInstallation(user *models.User, internalId int64) *app_error.AppError {
+	errNotFound := errors.New("no deleted installation to restore")
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := models.RestoreAdministeredInstallation(tx, internalId, DeletionGraceStart())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errNotFound
+		}
+
+		return models.AddMembership(tx, user.Id, internalId, models.RoleAdmin).Error
+	})
+	if errors.Is(err, errNotFound) {
+		return app_error.NewAppError(http.StatusGone, "The installation was deleted for good, remove the app from GitHub and install it again", err)
+	}
+	if err != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to restore the installation", err)
+	}
+
+	return nil
+}
+
+// PurgeExpiredData removes what was deleted before the grace period and the runs past the retention period
+func PurgeExpiredData() {
+	purged, err := models.PurgeDeleted(DeletionGraceStart())
+	if err != nil {
+		fmt.Println("could not purge deleted data: ", err)
+	} else if purged > 0 {
+		fmt.Printf("purged %d deleted users, installations and repositories\n", purged)
+	}
+
+	if config.C.RunRetentionDays <= 0 {
+		return
+	}
+
+	result := models.PurgeRunsEndedBefore(time.Now().AddDate(0, 0, -int(config.C.RunRetentionDays)))
+	if result.Error != nil {
+		fmt.Println("could not purge old runs: ", result.Error)
+	} else if result.RowsAffected > 0 {
+		fmt.Printf("purged %d runs past the retention period\n", result.RowsAffected)
+	}
+}
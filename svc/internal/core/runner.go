@@ -0,0 +1,152 @@
+package core
+
+import (
+	"buildkansen/config"
+	githubApi "buildkansen/github"
+	"buildkansen/internal/app_error"
+	"buildkansen/models"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/google/uuid"
+)
+
+const offlineRunnerStatus = "offline"
+
+// DeregisterRunner removes the runner registration named runnerName from the repository, if GitHub still has it
+func DeregisterRunner(repositoryInternalId int64, runnerName string) *app_error.AppError {
+	repository, err := models.FindRepositoryWithInstallation(repositoryInternalId)
+	if err != nil {
+		return app_error.NewAppError(http.StatusNotFound, "Failed to find a repository for this runner", err)
+	}
+
+	client, err := githubApi.NewClient(config.C.GithubAppId, repository.Installation.Id, config.C.GithubPrivateKeyBase64)
+	if err != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to create a GitHub client", err)
+	}
+
+	removed, err := deregisterRunner(client, repository.Installation.AccountLogin, repository.Name, runnerName)
+	if err != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to remove the runner", err)
+	}
+
+	if removed {
+		fmt.Printf("deregistered runner %s from %s\n", runnerName, repository.FullName)
+	}
+	return nil
+}
+
+// runnerClient is the part of the GitHub client that manages a repository's runner registrations
+type runnerClient interface {
+	ListRunners(owner string, repo string) ([]*github.Runner, error)
+	RemoveRunner(owner string, repo string, runnerId int64) (*github.Response, error)
+}
+
+// deregisterRunner removes the runner named runnerName, and is false when GitHub doesn't have it
+func deregisterRunner(client runnerClient, owner string, repo string, runnerName string) (bool, error) {
+	runners, err := client.ListRunners(owner, repo)
+	if err != nil {
+		return false, err
+	}
+
+	for _, runner := range runners {
+		if runner.GetName() != runnerName {
+			continue
+		}
+
+		_, err = client.RemoveRunner(owner, repo, runner.GetID())
+		return err == nil, err
+	}
+
+	return false, nil
+}
+
+// SweepStaleRunners removes offline runner registrations that we created but no longer have a VM for
+func SweepStaleRunners() {
+	baseVMNames, err := models.BaseVMNames()
+	if err != nil {
+		fmt.Println("could not fetch base VM names: ", err)
+		return
+	}
+
+	repositories, err := models.FetchRepositoriesWithRuns()
+	if err != nil {
+		fmt.Println("could not fetch repositories to sweep: ", err)
+		return
+	}
+
+	clients := make(map[int64]*githubApi.Client)
+
+	for _, repository := range repositories {
+		installationId := repository.Installation.Id
+		client, ok := clients[installationId]
+		if !ok {
+			client, err = githubApi.NewClient(config.C.GithubAppId, installationId, config.C.GithubPrivateKeyBase64)
+			if err != nil {
+				fmt.Printf("could not create a client for installation %d: %s\n", installationId, err)
+				continue
+			}
+			clients[installationId] = client
+		}
+
+		swept, err := sweepStaleRunners(client, repository.Installation.AccountLogin, repository.Name, baseVMNames, models.VMInstanceExists)
+		if err != nil {
+			fmt.Printf("could not sweep the runners of %s: %s\n", repository.FullName, err)
+		}
+		for _, name := range swept {
+			fmt.Printf("swept stale runner %s from %s\n", name, repository.FullName)
+		}
+	}
+}
+
+// sweepStaleRunners removes the offline runners of the base VMs that no VM holds
+func sweepStaleRunners(client runnerClient, owner string, repo string, baseVMNames []string, vmExists func(string) (bool, error)) ([]string, error) {
+	runners, err := client.ListRunners(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	swept := make([]string, 0)
+	for _, runner := range runners {
+		if runner.GetStatus() != offlineRunnerStatus || !isManagedRunnerName(baseVMNames, runner.GetName()) {
+			continue
+		}
+
+		exists, err := vmExists(runner.GetName())
+		if err != nil || exists {
+			continue
+		}
+
+		_, err = client.RemoveRunner(owner, repo, runner.GetID())
+		if err != nil {
+			fmt.Printf("could not remove stale runner %s: %s\n", runner.GetName(), err)
+			continue
+		}
+
+		swept = append(swept, runner.GetName())
+	}
+
+	return swept, nil
+}
+
+// RunnerName is the name a guest VM and its runner registration are given, <BaseVMName>-<uuid>
+func RunnerName(baseVMName string) string {
+	return baseVMName + "-" + uuid.New().String()
+}
+
+func isManagedRunnerName(baseVMNames []string, name string) bool {
+	for _, baseVMName := range baseVMNames {
+		prefix := baseVMName + "-"
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if _, err := uuid.Parse(strings.TrimPrefix(name, prefix)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-github/v57/github"
+)
+
+type fakeRunnerClient struct {
+	runners   []*github.Runner
+	listErr   error
+	removeErr error
+	removed   []int64
+}
+
+func (f *fakeRunnerClient) ListRunners(owner string, repo string) ([]*github.Runner, error) {
+	return f.runners, f.listErr
+}
+
+func (f *fakeRunnerClient) RemoveRunner(owner string, repo string, runnerId int64) (*github.Response, error) {
+	if f.removeErr != nil {
+		return nil, f.removeErr
+	}
+
+	f.removed = append(f.removed, runnerId)
+	return nil, nil
+}
+
+func runner(id int64, name string, status string) *github.Runner {
+	return &github.Runner{ID: github.Int64(id), Name: github.String(name), Status: github.String(status)}
+}
+
+const (
+	managedRunner = "sonoma-runner-md-8a1c7a3e-2b1d-4c5e-9f3a-0d6b7e8f9a10"
+	otherRunner   = "sonoma-runner-md-1f2e3d4c-5b6a-4978-8695-a4b3c2d1e0f9"
+)
+
+func TestDeregisterRunner(t *testing.T) {
+	client := &fakeRunnerClient{runners: []*github.Runner{runner(1, otherRunner, "online"), runner(2, managedRunner, "online")}}
+
+	removed, err := deregisterRunner(client, "tramlinehq", "site", managedRunner)
+	if err != nil || !removed {
+		t.Fatalf("deregisterRunner = %v, %v, want true, nil", removed, err)
+	}
+	if len(client.removed) != 1 || client.removed[0] != 2 {
+		t.Fatalf("removed %v, want [2]", client.removed)
+	}
+}
+
+func TestDeregisterRunnerGone(t *testing.T) {
+	client := &fakeRunnerClient{runners: []*github.Runner{runner(1, otherRunner, "online")}}
+
+	removed, err := deregisterRunner(client, "tramlinehq", "site", managedRunner)
+	if err != nil || removed || len(client.removed) != 0 {
+		t.Fatalf("deregisterRunner = %v, %v and removed %v, want nothing removed", removed, err, client.removed)
+	}
+}
+
+func TestDeregisterRunnerFailure(t *testing.T) {
+	client := &fakeRunnerClient{runners: []*github.Runner{runner(2, managedRunner, "online")}, removeErr: errors.New("boom")}
+
+	removed, err := deregisterRunner(client, "tramlinehq", "site", managedRunner)
+	if err == nil || removed {
+		t.Fatalf("deregisterRunner = %v, %v, want false and the error", removed, err)
+	}
+}
+
+func TestSweepStaleRunners(t *testing.T) {
+	client := &fakeRunnerClient{runners: []*github.Runner{
+		runner(1, managedRunner, "offline"),
+		runner(2, otherRunner, "offline"),          // its VM still holds it
+		runner(3, "sonoma-runner-md-3", "offline"), // not a name we give out
+		runner(4, "someone-elses-runner", "offline"),
+		runner(5, "sonoma-runner-md-6c5b4a39-2817-4605-9f4e-3d2c1b0a9f8e", "online"),
+	}}
+	vmExists := func(name string) (bool, error) {
+		return name == otherRunner, nil
+	}
+
+	swept, err := sweepStaleRunners(client, "tramlinehq", "site", []string{"sonoma-runner-md"}, vmExists)
+	if err != nil {
+		t.Fatalf("sweepStaleRunners: %s", err)
+	}
+	if len(swept) != 1 || swept[0] != managedRunner || len(client.removed) != 1 || client.removed[0] != 1 {
+		t.Fatalf("swept %v and removed %v, want only %s", swept, client.removed, managedRunner)
+	}
+}
+
+func TestSweepStaleRunnersKeepsUnknownVMs(t *testing.T) {
+	client := &fakeRunnerClient{runners: []*github.Runner{runner(1, managedRunner, "offline")}}
+	vmExists := func(name string) (bool, error) {
+		return false, errors.New("database is down")
+	}
+
+	swept, err := sweepStaleRunners(client, "tramlinehq", "site", []string{"sonoma-runner-md"}, vmExists)
+	if err != nil || len(swept) != 0 || len(client.removed) != 0 {
+		t.Fatalf("swept %v and removed %v, %v, want nothing removed when the VMs can't be checked", swept, client.removed, err)
+	}
+}
+
+func TestSweepStaleRunnersListFailure(t *testing.T) {
+	client := &fakeRunnerClient{listErr: errors.New("rate limited")}
+
+	if _, err := sweepStaleRunners(client, "tramlinehq", "site", []string{"sonoma-runner-md"}, nil); err == nil {
+		t.Fatal("sweepStaleRunners succeeded, want the listing error")
+	}
+}
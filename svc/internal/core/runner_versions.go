@@ -0,0 +1,116 @@
+package core
+
+import (
+	"buildkansen/config"
+	"buildkansen/internal/metrics"
+	"buildkansen/internal/runner_versions"
+	"buildkansen/models"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	runnerExpiryMetric = "buildkansen_image_runner_expiry_seconds"
+	runnerStatusMetric = "buildkansen_image_runner_status"
+)
+
+type RunnerVersionWarning struct {
+	Image  models.Image
+	Status models.RunnerStatus
+}
+
+// CheckRunnerVersions records when GitHub will stop accepting the runner version of each image
+func CheckRunnerVersions() {
+	releases, err := runner_versions.FetchReleases(config.C.RunnerReleasesUrl)
+	if err != nil {
+		fmt.Println("could not fetch runner releases: ", err)
+		return
+	}
+
+	latest, found := runner_versions.Latest(releases)
+	if !found {
+		fmt.Println("no runner releases found")
+		return
+	}
+
+	images, err := models.FetchImages()
+	if err != nil {
+		fmt.Println("could not fetch images: ", err)
+		return
+	}
+
+	grace := time.Duration(config.C.RunnerUpdateGraceDays) * 24 * time.Hour
+	metrics.ResetGauge(runnerExpiryMetric)
+	metrics.ResetGauge(runnerStatusMetric)
+
+	for _, image := range images {
+		if len(image.RunnerVersion) == 0 {
+			continue
+		}
+
+		var deadline sql.NullTime
+		if t, found := runner_versions.Deadline(image.RunnerVersion, releases, grace); found {
+			deadline = sql.NullTime{Time: t, Valid: true}
+		}
+
+		result := models.UpdateImageRunnerVersions(&image, latest.Version(), deadline)
+		if result.Error != nil {
+			fmt.Printf("could not update runner versions for image %s@%s: %s\n", image.Name, image.Version, result.Error)
+			continue
+		}
+		image.RunnerDeadline = deadline
+
+		status := image.RunnerStatus(runnerWarnWithin())
+		labels := map[string]string{"image": image.Name, "version": image.Version, "runner_version": image.RunnerVersion}
+
+		if deadline.Valid {
+			metrics.SetGauge(runnerExpiryMetric, "Seconds until GitHub stops accepting the image's runner version", labels, time.Until(deadline.Time).Seconds())
+		}
+
+		for _, s := range []models.RunnerStatus{models.RunnerCurrent, models.RunnerExpiring, models.RunnerDead} {
+			value := 0.0
+			if s == status {
+				value = 1
+			}
+			metrics.SetGauge(runnerStatusMetric, "Whether the image's runner version is current, expiring or dead", withStatus(labels, s), value)
+		}
+
+		if status != models.RunnerCurrent {
+			fmt.Printf("image %s@%s has runner %s which is %s (latest is %s, deadline %s)\n",
+				image.Name, image.Version, image.RunnerVersion, status, latest.Version(), deadline.Time.Format(time.RFC3339))
+		}
+	}
+}
+
+// RunnerVersionWarnings lists the images in use whose runner version is expiring or dead
+func RunnerVersionWarnings() []RunnerVersionWarning {
+	images, err := models.FetchImagesInUse()
+	if err != nil {
+		fmt.Println("could not fetch images: ", err)
+		return nil
+	}
+
+	warnings := make([]RunnerVersionWarning, 0)
+	for _, image := range images {
+		status := image.RunnerStatus(runnerWarnWithin())
+		if status != models.RunnerCurrent {
+			warnings = append(warnings, RunnerVersionWarning{Image: image, Status: status})
+		}
+	}
+
+	return warnings
+}
+
+func runnerWarnWithin() time.Duration {
+	return time.Duration(config.C.RunnerWarnDays) * 24 * time.Hour
+}
+
+func withStatus(labels map[string]string, status models.RunnerStatus) map[string]string {
+	l := map[string]string{"status": string(status)}
+	for k, v := range labels {
+		l[k] = v
+	}
+
+	return l
+}
@@ -6,11 +6,14 @@ import (
 	githubApi "buildkansen/github"
 	"buildkansen/internal/app_error"
 	"buildkansen/models"
+	"errors"
 	"net/http"
+
+	"github.com/google/go-github/v57/github"
 )
 
-func CreateOrUpdateUser(id int64, name string, email string) (*app_error.AppError, *models.User) {
-	result, user := models.UpsertUser(id, name, email)
+func CreateOrUpdateUser(id int64, login string, name string, email string) (*app_error.AppError, *models.User) {
+	result, user := models.UpsertUser(id, login, name, email)
 	if result.Error != nil {
 		return app_error.NewAppError(http.StatusInternalServerError, "Failed to create/update the user", result.Error), nil
 	}
@@ -18,14 +21,40 @@ func CreateOrUpdateUser(id int64, name string, email string) (*app_error.AppErro
 	return nil, &user
 }
 
-func CreateInstallation(userId int64, installationId int64) *app_error.AppError {
+// CreateInstallation saves the installation with the user as its owner, or as an admin when it exists already
+func CreateInstallation(user *models.User, installationId int64) *app_error.AppError {
 	client, err := githubApi.NewClient(config.C.GithubAppId, installationId, config.C.GithubPrivateKeyBase64)
-
 	if err != nil {
 		return app_error.NewAppError(http.StatusInternalServerError, "Failed to create a GitHub client", err)
 	}
 
-	githubInstallation, _, _ := client.GetInstallation()
+	githubInstallation, _, err := client.GetInstallation()
+	if err != nil {
+		return app_error.NewAppError(http.StatusNotFound, "The installation was not found on GitHub", err)
+	}
+
+	if appError := authorizeInstallation(client, user, githubInstallation); appError != nil {
+		return appError
+	}
+
+	existing, err := models.FindEntityById(models.Installation{}, installationId)
+	if err == nil {
+		installation := existing.(models.Installation)
+		result := models.AddMembership(db.DB, user.Id, installation.InternalId, models.RoleAdmin)
+		if result.Error != nil {
+			return app_error.NewAppError(http.StatusInternalServerError, "Failed to join the installation", result.Error)
+		}
+
+		return nil
+	}
+
+	// installing the app again during the grace period brings a deleted installation back, the user administers its
+	// account so they join it as an admin unless they were a member already
+	deleted, err := models.FindDeletedInstallation(installationId)
+	if err == nil {
+		return RestoreAdministeredInstallation(user, deleted.InternalId)
+	}
+
 	githubRepositories, _, _ := client.GetInstallationRepos()
 
 	tx := db.DB.Begin()
@@ -36,7 +65,7 @@ func CreateInstallation(userId int64, installationId int64) *app_error.AppError
 		AccountID:        *githubInstallation.Account.ID,
 		AccountLogin:     *githubInstallation.Account.Login,
 		AccountAvatarUrl: *githubInstallation.Account.AvatarURL,
-		UserId:           userId,
+		UserId:           user.Id,
 	}
 	result := tx.Create(&installation)
 
@@ -45,6 +74,12 @@ func CreateInstallation(userId int64, installationId int64) *app_error.AppError
 		return app_error.NewAppError(http.StatusInternalServerError, "Failed to save the installation", result.Error)
 	}
 
+	result = models.AddMembership(tx, user.Id, installation.InternalId, models.RoleOwner)
+	if result.Error != nil {
+		tx.Rollback()
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to save the installation", result.Error)
+	}
+
 	for _, repo := range githubRepositories.Repositories {
 		repository := models.Repository{
 			Id:             *repo.ID,
@@ -67,6 +102,29 @@ func CreateInstallation(userId int64, installationId int64) *app_error.AppError
 	return nil
 }
 
+// authorizeInstallation refuses users who don't own the account the app is installed on
+func authorizeInstallation(client *githubApi.Client, user *models.User, installation *github.Installation) *app_error.AppError {
+	forbidden := app_error.NewAppError(http.StatusForbidden, "Only the account's owners can add this installation, "+
+		"sign in again to join it if you are a member", errors.New("user does not own the installation's account"))
+
+	account := installation.GetAccount()
+	switch account.GetType() {
+	case "User":
+		if account.GetID() != user.Id {
+			return forbidden
+		}
+	case "Organization":
+		membership, _, err := client.GetOrgMembership(account.GetLogin(), user.Login)
+		if err != nil || membership.GetState() != "active" || membership.GetRole() != "admin" {
+			return forbidden
+		}
+	default:
+		return forbidden
+	}
+
+	return nil
+}
+
 func HasUserAlreadyInstalled(user *models.User) bool {
 	installations, repositories, _ := models.FetchUserData(user)
 	if len(installations) != 0 && len(repositories) != 0 {
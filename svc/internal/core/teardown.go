@@ -0,0 +1,66 @@
+package core
+
+import (
+	"buildkansen/db"
+	"buildkansen/internal/app_error"
+	"buildkansen/internal/checks"
+	"buildkansen/models"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RequestTeardown hands the VM to the scheduler of its host, which purges it
+func RequestTeardown(vm *models.VM) *app_error.AppError {
+	result := models.RequestVMTeardown(db.DB, vm)
+	if result.Error != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to request the VM's teardown", result.Error)
+	}
+
+	PublishPoolChange()
+	return nil
+}
+
+// TearDownVMs purges the host's VMs that were asked to be torn down or whose run has ended
+func TearDownVMs(host string) {
+	vms, err := models.VMsToTearDown(host)
+	if err != nil {
+		fmt.Println("could not fetch the VMs to tear down: ", err)
+		return
+	}
+
+	for _, vm := range vms {
+		TearDownVM(vm)
+	}
+}
+
+// TearDownVM purges the VM and, when its run has ended, meters the run and reports it completed
+func TearDownVM(vm models.VM) {
+	var jobRun *models.WorkflowJobRun
+	if vm.WorkflowJobRunId.Valid {
+		found, err := models.FindAnyRun(vm.WorkflowJobRunId.Int64)
+		if err != nil {
+			fmt.Printf("could not find the run of VM %d (%s): %s\n", vm.Id, vm.VMInstanceName, err)
+		} else {
+			jobRun = found
+		}
+	}
+
+	appError := PurgeVM(vm)
+	if appError != nil {
+		fmt.Printf("could not tear down VM %d (%s): %s\n", vm.Id, vm.VMInstanceName, appError.Message)
+		if jobRun != nil && jobRun.EndedAt.Valid && !jobRun.FailureReason.Valid {
+			models.FailWorkflowJobRun(jobRun.Id, jobRun.RepositoryId, appError.Message)
+		}
+		return
+	}
+
+	if jobRun == nil || !jobRun.EndedAt.Valid {
+		return
+	}
+
+	RecordUsage(jobRun.Id, jobRun.RepositoryId, &vm, time.Now())
+	if jobRun.Conclusion.String != models.TimedOutConclusion {
+		checks.Completed(jobRun.Id, jobRun.RepositoryId, jobRun.Conclusion.String)
+	}
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"buildkansen/config"
+	"buildkansen/internal/app_error"
+	"buildkansen/internal/checks"
+	"buildkansen/internal/labels"
+	"buildkansen/models"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EnforceTimeouts stops jobs that have held their VM past their maximum runtime
+func EnforceTimeouts() {
+	jobRuns, err := models.RunningWorkflowJobRuns()
+	if err != nil {
+		fmt.Println("could not fetch running jobs: ", err)
+		return
+	}
+
+	runtimes, err := models.FetchLabelRuntimes()
+	if err != nil {
+		fmt.Println("could not fetch the labels' maximum runtimes: ", err)
+		return
+	}
+
+	for _, jobRun := range jobRuns {
+		limit := MaxRuntime(runtimes, &jobRun)
+		if time.Since(jobRun.AssignedAt.Time) <= limit {
+			continue
+		}
+
+		timeOut(&jobRun, limit)
+	}
+}
+
+// MaxRuntime is how long a job may hold a VM: the installation's override, else its label's, else the default
+func MaxRuntime(runtimes []models.LabelRuntime, jobRun *models.WorkflowJobRun) time.Duration {
+	minutes := config.C.DefaultMaxRuntimeMinutes
+
+	if runtime := models.LabelRuntimeFor(runtimes, labels.Parse(jobRun.Labels)); runtime != nil && runtime.MaxRuntimeMinutes > 0 {
+		minutes = int64(runtime.MaxRuntimeMinutes)
+	}
+
+	if installation := jobRun.Repository.Installation; installation.MaxRuntimeMinutes > 0 {
+		minutes = int64(installation.MaxRuntimeMinutes)
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+// SetMaxRuntime sets the maximum runtime of a label's jobs, 0 goes back to the default
+func SetMaxRuntime(label string, minutes int) (*models.LabelRuntime, *app_error.AppError) {
+	runtime := &models.LabelRuntime{Label: strings.ToLower(strings.TrimSpace(label)), MaxRuntimeMinutes: minutes}
+	if len(runtime.Label) == 0 {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, "A maximum runtime is for exactly one label", nil)
+	}
+
+	result := models.SaveLabelRuntime(runtime)
+	if result.Error != nil {
+		return nil, app_error.NewAppError(http.StatusInternalServerError, "Failed to save the maximum runtime", result.Error)
+	}
+
+	fmt.Printf("set the maximum runtime of %s to %d minutes\n", runtime.Label, minutes)
+	return runtime, nil
+}
+
+func timeOut(jobRun *models.WorkflowJobRun, limit time.Duration) {
+	reason := fmt.Sprintf("The job held its VM for more than %d minutes and was timed out.", int64(limit.Minutes()))
+	fmt.Printf("timing out workflow job run %d: %s\n", jobRun.Id, reason)
+
+	// close the run first, so the completed webhook the cancellation triggers leaves it alone
+	result := models.TimeOutWorkflowJobRun(jobRun.Id, jobRun.RepositoryId, reason)
+	if result.Error != nil || result.RowsAffected == 0 {
+		fmt.Printf("could not time out workflow job run %d: %v\n", jobRun.Id, result.Error)
+		return
+	}
+
+	recordUsageWithoutVM(jobRun.Id, jobRun.RepositoryId)
+
+	appError := CancelWorkflowRun(&jobRun.Repository, jobRun.WorkflowRunId)
+	if appError != nil {
+		fmt.Printf("could not cancel workflow run %d: %s\n", jobRun.WorkflowRunId, appError.Message)
+	}
+
+	PublishRunChange(jobRun.Id, jobRun.RepositoryId)
+	checks.TimedOut(jobRun.Id, jobRun.RepositoryId, reason)
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"buildkansen/internal/app_error"
+	"buildkansen/internal/labels"
+	"buildkansen/models"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+const usageMonthFormat = "2006-01"
+
+// RecordUsage stores the run's queue and run time and meters the VM it held
+func RecordUsage(jobId int64, repoId int64, vm *models.VM, tornDownAt time.Time) {
+	jobRun, err := models.FindWorkflowJobRun(jobId, repoId)
+	if err != nil {
+		fmt.Printf("could not find workflow job run %d to meter: %s\n", jobId, err)
+		return
+	}
+
+	result := models.StoreWorkflowJobRunDurations(jobRun)
+	if result.Error != nil {
+		fmt.Printf("could not store durations for workflow job run %d: %s\n", jobId, result.Error)
+	}
+
+	if !jobRun.AssignedAt.Valid {
+		return
+	}
+
+	label, host, endedAt := labels.Join(labels.Parse(jobRun.Labels)), jobRun.VMHost, jobRun.EndedAt.Time
+	if vm != nil {
+		label, host, endedAt = vm.GithubRunnerLabel, vm.Host, tornDownAt
+	}
+
+	_, runTime := jobRun.Durations()
+	record := models.UsageRecord{
+		WorkflowJobRunId: sql.NullInt64{Int64: jobRun.InternalId, Valid: true},
+		InstallationId:   jobRun.Repository.InstallationId,
+		RepositoryId:     jobRun.RepositoryId,
+		JobId:            jobRun.Id,
+		Repository:       jobRun.Repository.FullName,
+		WorkflowName:     jobRun.WorkflowName,
+		JobName:          jobRun.Name,
+		Label:            label,
+		Host:             host,
+		VMSeconds:        int64(endedAt.Sub(jobRun.AssignedAt.Time).Seconds()),
+		BillableMinutes:  BillableMinutes(runTime),
+		StartedAt:        jobRun.AssignedAt.Time,
+		EndedAt:          endedAt,
+	}
+
+	result = models.CreateUsageRecord(&record)
+	if result.Error != nil {
+		fmt.Printf("could not record usage for workflow job run %d: %s\n", jobId, result.Error)
+	}
+}
+
+// recordUsageWithoutVM meters a run that has just ended when no VM holds it any more, so no teardown will meter it
+func recordUsageWithoutVM(jobId int64, repoId int64) {
+	jobRun, err := models.FindWorkflowJobRun(jobId, repoId)
+	if err != nil {
+		fmt.Printf("could not find workflow job run %d to meter: %s\n", jobId, err)
+		return
+	}
+
+	held, err := models.RunHoldsVM(jobRun.InternalId)
+	if err != nil {
+		fmt.Printf("could not find the VM of workflow job run %d: %s\n", jobId, err)
+		return
+	}
+
+	if !held {
+		RecordUsage(jobId, repoId, nil, time.Time{})
+	}
+}
+
+// BillableMinutes rounds a job's run time up to the whole minute, the way GitHub bills each job
+func BillableMinutes(runTime time.Duration) int64 {
+	if runTime <= 0 {
+		return 0
+	}
+
+	return int64(math.Ceil(runTime.Minutes()))
+}
+
+// ParseUsageMonth reads a YYYY-MM month, defaulting to the current one
+func ParseUsageMonth(month string) (time.Time, *app_error.AppError) {
+	if len(month) == 0 {
+		return time.Now().UTC(), nil
+	}
+
+	t, err := time.Parse(usageMonthFormat, month)
+	if err != nil {
+		return time.Time{}, app_error.NewAppError(http.StatusBadRequest, "The month should look like 2024-01", err)
+	}
+
+	return t, nil
+}
+
+// FindUsageInstallation resolves a GitHub installation id to our internal id, 0 is every installation
+func FindUsageInstallation(githubId int64) (int64, *app_error.AppError) {
+	if githubId == 0 {
+		return 0, nil
+	}
+
+	i, err := models.FindEntityById(models.Installation{}, githubId)
+	if err != nil {
+		return 0, app_error.NewAppError(http.StatusNotFound, "Failed to find the installation", err)
+	}
+
+	return i.(models.Installation).InternalId, nil
+}
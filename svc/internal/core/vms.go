@@ -0,0 +1,52 @@
+package core
+
+import (
+	"buildkansen/internal/app_error"
+	"buildkansen/internal/labels"
+	"buildkansen/models"
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// BindVM parks a VM cloned from the base VM on the host in the pool, with the runner labels of its image
+func BindVM(baseVMName string, runnerLabel string, host string, image string, imageVersion string) (*models.VM, *app_error.AppError) {
+	if len(image) == 0 {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, "A VM is bound with the image it was parked from", nil)
+	}
+
+	found, err := models.FindImage(image, imageVersion)
+	if err != nil {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, fmt.Sprintf("The image %s is not published", image), err)
+	}
+
+	imageLabels := labels.Join(found.LabelSet())
+	if len(imageLabels) == 0 {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, fmt.Sprintf("The image %s@%s has no runner labels, publish it with them", found.Name, found.Version), nil)
+	}
+	if len(runnerLabel) > 0 && labels.Join(labels.Parse(runnerLabel)) != imageLabels {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, fmt.Sprintf("The VM's labels must be those of its image, %s", imageLabels), nil)
+	}
+
+	imageId := sql.NullInt64{Int64: found.Id, Valid: true}
+	result, vm := models.CreateVM(baseVMName, imageLabels, host, imageId)
+	if result.Error != nil {
+		return nil, app_error.NewAppError(http.StatusUnprocessableEntity, "Could not create VM", result.Error)
+	}
+
+	PublishPoolChange()
+	return &vm, nil
+}
+
+// UnbindVMs takes the host's free VMs parked from the base VM out of the pool, and counts the busy ones left behind
+func UnbindVMs(baseVMName string, host string) ([]models.VM, int64, *app_error.AppError) {
+	removed, busy, err := models.UnbindVMs(baseVMName, host)
+	if err != nil {
+		return nil, 0, app_error.NewAppError(http.StatusInternalServerError, "Could not unbind the VM", err)
+	}
+
+	if len(removed) > 0 {
+		PublishPoolChange()
+	}
+	return removed, busy, nil
+}
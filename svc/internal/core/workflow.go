@@ -2,7 +2,10 @@ package core
 
 import (
 	"buildkansen/config"
+	githubApi "buildkansen/github"
 	"buildkansen/internal/app_error"
+	"buildkansen/internal/checks"
+	"buildkansen/internal/labels"
 	"buildkansen/models"
 	"fmt"
 	"net/http"
@@ -32,16 +35,19 @@ func ValidateWorkflow(installationId int64, repositoryId int64) (*models.Install
 	return &installation, repository, nil
 }
 
-func FindValidRunnerName(runnerLabels []string) (string, bool) {
-	for _, label := range runnerLabels {
-		for _, runnerName := range config.C.ValidRunnerNames {
-			if label == runnerName {
-				return label, true
-			}
-		}
+// MatchRunnerLabels decides whether a job is meant for our runners, and returns its runs-on labels normalised
+func MatchRunnerLabels(jobLabels []string) (string, bool) {
+	known, err := models.RunnerLabels()
+	if err != nil {
+		fmt.Println("could not fetch runner labels: ", err)
+		return "", false
+	}
+
+	if !labels.Claims(known, jobLabels) {
+		return "", false
 	}
 
-	return "", false
+	return labels.Join(jobLabels), true
 }
 
 func ProcessWorkflowRun(jobId int64, runStatus string, repoId int64) {
@@ -49,41 +55,131 @@ func ProcessWorkflowRun(jobId int64, runStatus string, repoId int64) {
 	result := models.ProcessWorkflowJobRun(jobId, repoId, runStatus)
 	if result.Error != nil {
 		fmt.Printf("could not update workflow job for : %d", jobId)
+		return
 	}
+
+	PublishRunChange(jobId, repoId)
+	checks.Started(jobId, repoId)
 }
 
-func CompleteWorkflow(jobId int64, runId int64, runStatus string, runConclusion string, repoId int64, endedAt time.Time) *app_error.AppError {
-	vm, err := models.FindEntity(models.VM{}, runId, "external_run_id")
-	if err != nil {
-		fmt.Println("Error:", err)
-		return app_error.NewAppError(http.StatusNotFound, "No valid runner was found", err)
+// FailWorkflow records why our infrastructure could not run a job, so the failure is not silent
+func FailWorkflow(jobId int64, repoId int64, reason string) {
+	fmt.Printf("recording infrastructure failure for: %d: %s\n", jobId, reason)
+	result := models.FailWorkflowJobRun(jobId, repoId, reason)
+	if result.Error != nil {
+		fmt.Printf("could not record failure for workflow job: %d", jobId)
 	}
 
-	go func() {
-		fmt.Printf("updating workflow job run for: %d with conclusion: %s, and status: %s\n", jobId, runConclusion, runStatus)
-		result := models.CompleteWorkflowJobRun(jobId, repoId, runStatus, runConclusion, endedAt)
-		if result.Error != nil {
-			fmt.Printf("could not update workflow job for : %d", jobId)
-		}
-	}()
+	PublishRunChange(jobId, repoId)
+	checks.Failed(jobId, repoId, reason)
+}
+
+// CompleteWorkflow closes the run with GitHub's outcome
+func CompleteWorkflow(jobId int64, runStatus string, runConclusion string, repoId int64, endedAt time.Time) *app_error.AppError {
+	fmt.Printf("updating workflow job run for: %d with conclusion: %s, and status: %s\n", jobId, runConclusion, runStatus)
+	result := models.CompleteWorkflowJobRun(jobId, repoId, runStatus, runConclusion, endedAt)
+	if result.Error != nil {
+		fmt.Printf("could not update workflow job for : %d", jobId)
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to complete the workflow job run", result.Error)
+	} else if result.RowsAffected == 0 {
+		fmt.Printf("workflow job run %d was already closed\n", jobId)
+		return nil
+	}
 
-	vmModel := vm.(models.VM)
+	recordUsageWithoutVM(jobId, repoId)
+	PublishRunChange(jobId, repoId)
+	return nil
+}
+
+// PurgeVM tears down the guest, removes its runner from GitHub even if that failed and frees the slot
+func PurgeVM(vm models.VM) *app_error.AppError {
 	args := []string{
-		"-n", vmModel.VMInstanceName,
+		"-n", vm.VMInstanceName,
 	}
 	fmt.Printf("Executing purge script with following args: %v", args)
 	cmd := exec.Command(purgeScript, args...)
 	cmd.Dir = purgeScriptDir
-	err = cmd.Run()
-	if err != nil {
-		fmt.Println("Error:", err)
-		return app_error.NewAppError(http.StatusInternalServerError, "Failed to purge the VM", err)
+	purgeErr := cmd.Run()
+	if purgeErr != nil {
+		fmt.Println("Error:", purgeErr)
+	}
+
+	if vm.RepositoryId.Valid {
+		appError := DeregisterRunner(vm.RepositoryId.Int64, vm.VMInstanceName)
+		if appError != nil {
+			fmt.Printf("could not deregister runner %s, the sweep will retry: %s\n", vm.VMInstanceName, appError.Message)
+		}
 	}
 
-	result := models.FreeVM(&vmModel)
+	if purgeErr != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to purge the VM", purgeErr)
+	}
+
+	result := models.FreeVM(&vm)
 	if result.Error != nil {
 		return app_error.NewAppError(http.StatusInternalServerError, "Failed to free the VM", result.Error)
 	}
+	PublishPoolChange()
+
+	return nil
+}
+
+// CancelWorkflowRun asks GitHub to cancel the whole workflow run, the repository must have its installation loaded
+func CancelWorkflowRun(repository *models.Repository, runId int64) *app_error.AppError {
+	client, err := githubApi.NewClient(config.C.GithubAppId, repository.Installation.Id, config.C.GithubPrivateKeyBase64)
+	if err != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to create a GitHub client", err)
+	}
+
+	_, err = client.CancelWorkflowRun(repository.Installation.AccountLogin, repository.Name, runId)
+	if err != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to cancel the workflow run", err)
+	}
+
+	return nil
+}
+
+// WorkflowJobLogsUrl asks GitHub where the job's logs can be downloaded from, the run must have its repository and installation loaded
+func WorkflowJobLogsUrl(jobRun *models.WorkflowJobRun) (string, *app_error.AppError) {
+	repository := jobRun.Repository
+	client, err := githubApi.NewClient(config.C.GithubAppId, repository.Installation.Id, config.C.GithubPrivateKeyBase64)
+	if err != nil {
+		return "", app_error.NewAppError(http.StatusInternalServerError, "Failed to create a GitHub client", err)
+	}
+
+	logsUrl, _, err := client.GetWorkflowJobLogs(repository.Installation.AccountLogin, repository.Name, jobRun.Id)
+	if err != nil {
+		return "", app_error.NewAppError(http.StatusNotFound, "The job's logs are not available", err)
+	}
+
+	return logsUrl.String(), nil
+}
+
+// CancelPendingJob takes a job that has not been given a VM off the queue and cancels its workflow run
+func CancelPendingJob(jobRun *models.WorkflowJobRun) *app_error.AppError {
+	result := models.MarkWorkflowJobRunCancelling(jobRun.Id, jobRun.RepositoryId)
+	if result.Error != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to cancel the job", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return app_error.NewAppError(http.StatusConflict, "Only queued jobs can be cancelled", nil)
+	}
+
+	appError := CancelWorkflowRun(&jobRun.Repository, jobRun.WorkflowRunId)
+	if appError != nil {
+		result = models.RestorePendingWorkflowJobRun(jobRun.Id, jobRun.RepositoryId)
+		if result.Error != nil {
+			fmt.Printf("could not put workflow job run %d back in the queue: %s\n", jobRun.Id, result.Error)
+		}
+		return appError
+	}
+
+	result = models.CancelPendingWorkflowJobRun(jobRun.Id, jobRun.RepositoryId)
+	if result.Error != nil {
+		return app_error.NewAppError(http.StatusInternalServerError, "Failed to cancel the job", result.Error)
+	}
 
+	PublishRunChange(jobRun.Id, jobRun.RepositoryId)
+	checks.Cancelled(jobRun.Id, jobRun.RepositoryId)
 	return nil
 }
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"sync"
+)
+
+const (
+	KindRun  = "run"
+	KindPool = "pool"
+)
+
+// subscriberBuffer is how many events a slow subscriber may fall behind before events to it are dropped
+const subscriberBuffer = 64
+
+// Event is a change pushed to dashboards, an InstallationId of 0 goes to every subscriber
+type Event struct {
+	Kind           string
+	InstallationId int64
+	Data           interface{}
+}
+
+// Subscription receives the events of a set of installations until it is unsubscribed
+type Subscription struct {
+	Events          chan Event
+	installationIds map[int64]bool
+}
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[*Subscription]bool)
+)
+
+func Subscribe(installationIds []int64) *Subscription {
+	s := &Subscription{
+		Events:          make(chan Event, subscriberBuffer),
+		installationIds: make(map[int64]bool),
+	}
+	for _, id := range installationIds {
+		s.installationIds[id] = true
+	}
+
+	mu.Lock()
+	subscribers[s] = true
+	mu.Unlock()
+
+	return s
+}
+
+func Unsubscribe(s *Subscription) {
+	mu.Lock()
+	delete(subscribers, s)
+	mu.Unlock()
+}
+
+// dispatch hands the event to every interested subscriber without blocking
+func dispatch(event Event) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for s := range subscribers {
+		if event.InstallationId != 0 && !s.installationIds[event.InstallationId] {
+			continue
+		}
+
+		select {
+		case s.Events <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"buildkansen/config"
+	"buildkansen/db"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// channel is the Postgres channel events travel on between processes
+const channel = "buildkansen_events"
+
+// listenRetryInterval is how long Listen waits before reconnecting after it lost the database
+const listenRetryInterval = 5 * time.Second
+
+// notification is an event on the wire, Data stays encoded since it is only ever sent on to the dashboards as JSON
+type notification struct {
+	Kind           string          `json:"kind"`
+	InstallationId int64           `json:"installation_id"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// Publish sends the event to the subscribers of every process that listens
+func Publish(event Event) {
+	data, err := json.Marshal(event.Data)
+	if err == nil {
+		var payload []byte
+		payload, err = json.Marshal(notification{Kind: event.Kind, InstallationId: event.InstallationId, Data: data})
+		if err == nil {
+			err = db.DB.Exec("SELECT pg_notify(?, ?)", channel, string(payload)).Error
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("could not publish the %s event to other processes: %s\n", event.Kind, err)
+		dispatch(event)
+	}
+}
+
+// Listen hands the events published by every process to this process's subscribers
+func Listen() {
+	for {
+		err := listen()
+		fmt.Printf("stopped listening for events, retrying in %s: %s\n", listenRetryInterval, err)
+		time.Sleep(listenRetryInterval)
+	}
+}
+
+// listen holds a connection of its own, outside the pool, since it waits on it for as long as it is up
+func listen() error {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, config.C.DbConnectionString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return err
+	}
+
+	for {
+		received, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var n notification
+		if err := json.Unmarshal([]byte(received.Payload), &n); err != nil {
+			fmt.Println("could not decode an event: ", err)
+			continue
+		}
+
+		dispatch(Event{Kind: n.Kind, InstallationId: n.InstallationId, Data: n.Data})
+	}
+}
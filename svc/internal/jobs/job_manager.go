@@ -1,69 +1,133 @@
 package jobs
 
 import (
+	"buildkansen/internal/core"
+	"buildkansen/internal/labels"
+	"buildkansen/internal/leader"
 	"buildkansen/models"
+	"context"
 	"fmt"
-	"sync"
 	"time"
 )
 
 const workerWaitTimeNs = time.Second * 5
 
-type jobManager struct {
-	jobQueue chan Job
-	wg       sync.WaitGroup
-}
-
-var jobQueueManager *jobManager
+// maintenanceLeadership is held by the one process that runs the background jobs for the whole pool
+const maintenanceLeadership = "maintenance"
 
-func Start() {
-	jobQueueManager = &jobManager{
-		jobQueue: make(chan Job),
-	}
-	jobQueueManager.startWorkers(1)
+// Start campaigns for the scheduler of the host and for the background jobs
+func Start(host string) {
+	go leader.Campaign("scheduler:"+host, func(ctx context.Context) {
+		schedule(ctx, host)
+	})
+	go leader.Campaign(maintenanceLeadership, maintain)
 }
 
-func (jm *jobManager) startWorkers(numWorkers int) {
-	for i := 1; i <= numWorkers; i++ {
-		jm.wg.Add(1)
-		go jm.worker(i)
+// schedule runs the scheduler of the host until ctx is cancelled
+func schedule(ctx context.Context, host string) {
+	released, err := models.ReleaseWorkflowJobRunClaims(host)
+	if err != nil {
+		fmt.Printf("could not release the runs claimed on %s: %s\n", host, err)
+	} else if released > 0 {
+		fmt.Printf("put %d runs claimed on %s back in the queue\n", released, host)
 	}
+
+	worker(ctx, host)
 }
 
-func (jm *jobManager) enqueueJob(job Job) {
-	jm.jobQueue <- job
+// maintain runs the background jobs that look after the whole pool until ctx is cancelled
+func maintain(ctx context.Context) {
+	startRunnerSweeper(ctx)
+	startQueueMonitor(ctx)
+	startRunnerVersionChecker(ctx)
+	startRetentionPurger(ctx)
+	<-ctx.Done()
 }
 
-// worker infinitely processes jobs from the job queue
-func (jm *jobManager) worker(id int) {
-	defer jm.wg.Done()
+// worker tears down the host's VMs that are done and places pending jobs on its free VMs until ctx is cancelled
+func worker(ctx context.Context, host string) {
+	for wait(ctx, 0) {
+		core.TearDownVMs(host)
 
-	for {
-		vmLock, err := models.InaugurateVM()
+		jobRuns, err := models.PendingWorkflowJobRuns()
 		if err != nil {
-			fmt.Printf("no available VMs")
-			time.Sleep(workerWaitTimeNs)
+			fmt.Println("could not fetch the job queue: ", err)
+			wait(ctx, workerWaitTimeNs)
 			continue
 		}
 
-		select {
-		case job, ok := <-jm.jobQueue:
-			if ok {
-				err := job.Execute(vmLock)
-				if err != nil {
-					fmt.Printf("worker %d could not process job: %+v\n", id, job)
-					vmLock.Close()
-					continue
-				}
+		byInstallation, byRepository, err := models.RunningJobCounts()
+		if err != nil {
+			fmt.Println("could not count running jobs: ", err)
+			wait(ctx, workerWaitTimeNs)
+			continue
+		}
 
-				vmLock.Commit(job.WorkflowRunId, job.RepositoryInternalId)
+		s := newScheduler(jobRuns, byInstallation, byRepository)
+		for jobRun := s.next(); jobRun != nil && ctx.Err() == nil; jobRun = s.next() {
+			vmLock, err := models.InaugurateVM(host, jobRun.Id, labels.Parse(jobRun.Labels))
+			if err != nil {
+				continue
 			}
 
-			fmt.Printf("worker %d processed job: %+v\n", id, job)
-			continue
-		case <-time.After(workerWaitTimeNs):
-			vmLock.Close()
-			fmt.Printf("no job found for %d nanoseconds, trying again\n", workerWaitTimeNs)
+			// the schedulers of other hosts see the same queue, the claim decides which of them runs the job
+			result := models.ClaimWorkflowJobRun(jobRun.Id, jobRun.RepositoryId, host)
+			if result.Error != nil || result.RowsAffected == 0 {
+				vmLock.Close()
+				continue
+			}
+
+			job := jobFromWorkflowJobRun(jobRun)
+			runnerName := core.RunnerName(vmLock.VM.BaseVMName)
+			result = vmLock.Assign(runnerName)
+			if result.Error != nil {
+				fmt.Printf("worker on %s could not assign a VM to job: %+v\n", host, job)
+				vmLock.Close()
+				go core.FailWorkflow(job.WorkflowJobId, job.RepositoryInternalId, fmt.Sprintf("could not assign the VM: %s", result.Error))
+				continue
+			}
+			vmLock.VM.VMInstanceName = runnerName
+
+			vmLock.Commit(jobRun.InternalId, job.RepositoryInternalId)
+			vm := vmLock.VM
+			s.started(jobRun)
+
+			err = job.Execute(vm)
+			if err != nil {
+				fmt.Printf("worker on %s could not process job: %+v\n", host, job)
+				// the VM goes back to the pool as it was handed out, the way a rolled back lock left it
+				if result := models.FreeVM(vm); result.Error != nil {
+					fmt.Printf("could not free VM %d: %s\n", vm.Id, result.Error)
+				}
+				go core.PublishPoolChange()
+				go core.FailWorkflow(job.WorkflowJobId, job.RepositoryInternalId, err.Error())
+				continue
+			}
+
+			go core.PublishPoolChange()
+			fmt.Printf("worker on %s processed job: %+v\n", host, job)
+		}
+
+		wait(ctx, workerWaitTimeNs)
+	}
+}
+
+// every runs fn now and then every interval, until ctx is cancelled
+func every(ctx context.Context, interval time.Duration, fn func()) {
+	go func() {
+		for wait(ctx, 0) {
+			fn()
+			wait(ctx, interval)
 		}
+	}()
+}
+
+// wait sleeps for d, and is false once ctx is cancelled
+func wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
 	}
 }
@@ -3,12 +3,12 @@ package jobs
 import (
 	"buildkansen/config"
 	githubApi "buildkansen/github"
+	"buildkansen/internal/checks"
+	"buildkansen/internal/core"
 	"buildkansen/models"
 	"fmt"
 	"os/exec"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 const (
@@ -21,8 +21,9 @@ type Job struct {
 	RepositoryInternalId  int64
 	RepositoryUrl         string
 	InstallationId        int64
-	RunnerName            string
+	RunnerLabels          string
 	WorkflowRunId         int64
+	WorkflowRunAttempt    int
 	WorkflowRunName       string
 	WorkflowRunStatus     string
 	WorkflowRunConclusion string
@@ -30,22 +31,27 @@ type Job struct {
 	WorkflowJobName       string
 	WorkflowJobUrl        string
 	WorkflowJobStart      time.Time
+	HeadSha               string
+	HeadBranch            string
+	Priority              int
 }
 
 func NewJob(accountLogin string,
 	repositoryInternalId int64, repositoryUrl string,
 	installationId int64,
-	runnerName string,
-	runId int64, runName string, runStatus string, runConclusion string,
-	jobId int64, jobName string, jobUrl string, jobStart time.Time) *Job {
+	runnerLabels string,
+	runId int64, runAttempt int, runName string, runStatus string, runConclusion string,
+	jobId int64, jobName string, jobUrl string, jobStart time.Time,
+	headSha string, headBranch string, priority int) *Job {
 
 	return &Job{
 		AccountLogin:          accountLogin,
 		RepositoryInternalId:  repositoryInternalId,
 		RepositoryUrl:         repositoryUrl,
 		InstallationId:        installationId,
-		RunnerName:            runnerName,
+		RunnerLabels:          runnerLabels,
 		WorkflowRunId:         runId,
+		WorkflowRunAttempt:    runAttempt,
 		WorkflowRunName:       runName,
 		WorkflowRunStatus:     runStatus,
 		WorkflowRunConclusion: runConclusion,
@@ -53,42 +59,98 @@ func NewJob(accountLogin string,
 		WorkflowJobName:       jobName,
 		WorkflowJobUrl:        jobUrl,
 		WorkflowJobStart:      jobStart,
+		HeadSha:               headSha,
+		HeadBranch:            headBranch,
+		Priority:              priority,
 	}
 }
 
-func (job *Job) Enqueue() {
-	go job.createWorkflowJobRun()
-	fmt.Printf("enqueuing job: %d\n", job.WorkflowJobId)
-	jobQueueManager.enqueueJob(*job)
+// jobFromWorkflowJobRun rebuilds a job from its persisted run, the run must have its repository and installation loaded
+func jobFromWorkflowJobRun(jobRun *models.WorkflowJobRun) *Job {
+	repository := jobRun.Repository
+	return NewJob(
+		repository.Installation.AccountLogin,
+		repository.InternalId,
+		repository.HtmlUrl(),
+		repository.Installation.Id,
+		jobRun.Labels,
+		jobRun.WorkflowRunId,
+		jobRun.RunAttempt,
+		jobRun.WorkflowName,
+		jobRun.Status,
+		jobRun.Conclusion.String,
+		jobRun.Id,
+		jobRun.Name,
+		jobRun.Url,
+		jobRun.StartedAt,
+		jobRun.HeadSha,
+		jobRun.HeadBranch,
+		jobRun.Priority,
+	)
 }
 
-func (job *Job) Execute(vmLock *models.VMLock) error {
-	repo, err := models.FindEntity(models.Repository{}, job.RepositoryInternalId, "internal_id")
+// Enqueue persists the job and creates its check run, the workers pick it up from the database
+func (job *Job) Enqueue() error {
+	fmt.Printf("enqueuing job: %d\n", job.WorkflowJobId)
+	err := job.createWorkflowJobRun()
 	if err != nil {
-		fmt.Println("could not find a repository for this webhook")
 		return err
 	}
 
-	client, err := githubApi.NewClient(config.C.GithubAppId, job.InstallationId, config.C.GithubPrivateKeyBase64)
-	token, _, err := client.GetActionsRegistrationToken(job.AccountLogin, repo.(models.Repository).Name)
+	checks.Queued(job.WorkflowJobId, job.RepositoryInternalId)
+	go core.PublishRunChange(job.WorkflowJobId, job.RepositoryInternalId)
+	return nil
+}
+
+// Refuse persists the job as failed so it never joins the queue, the installation's budget policy decides what GitHub is told
+func (job *Job) Refuse(reason string) error {
+	fmt.Printf("refusing job: %d: %s\n", job.WorkflowJobId, reason)
+	err := job.createWorkflowJobRun()
 	if err != nil {
-		fmt.Println("could not get registration token: ", err.Error())
 		return err
 	}
 
-	newUUID := uuid.New()
-	uuidString := newUUID.String()
-	runnerName := vmLock.VM.BaseVMName + "-" + uuidString
-	result := vmLock.Assign(runnerName)
+	result := models.FailWorkflowJobRun(job.WorkflowJobId, job.RepositoryInternalId, reason)
 	if result.Error != nil {
-		fmt.Printf("could not update runner for VM: %s", runnerName)
+		fmt.Println("could not record the refusal: ", result.Error)
 		return result.Error
 	}
+	go core.PublishRunChange(job.WorkflowJobId, job.RepositoryInternalId)
+
+	go core.RefuseWorkflow(job.WorkflowJobId, job.RepositoryInternalId, reason)
+	return nil
+}
+
+// Execute boots the job on the VM the worker committed to it
+func (job *Job) Execute(vm *models.VM) error {
+	result := models.AssignWorkflowJobRun(job.WorkflowJobId, job.RepositoryInternalId, vm)
+	if result.Error != nil {
+		fmt.Printf("could not record VM for workflow job run: %d", job.WorkflowJobId)
+	}
+
+	repo, err := models.FindEntity(models.Repository{}, job.RepositoryInternalId, "internal_id")
+	if err != nil {
+		fmt.Println("could not find a repository for this webhook")
+		return fmt.Errorf("could not find the repository: %w", err)
+	}
+
+	client, err := githubApi.NewClient(config.C.GithubAppId, job.InstallationId, config.C.GithubPrivateKeyBase64)
+	if err != nil {
+		fmt.Println("could not create a GitHub client: ", err.Error())
+		return fmt.Errorf("could not create a GitHub client: %w", err)
+	}
+
+	token, _, err := client.GetActionsRegistrationToken(job.AccountLogin, repo.(models.Repository).Name)
+	if err != nil {
+		fmt.Println("could not get registration token: ", err.Error())
+		return fmt.Errorf("could not get a runner registration token: %w", err)
+	}
+	checks.Booting(job.WorkflowJobId, job.RepositoryInternalId, vm)
 
 	args := []string{
-		"-b", vmLock.VM.BaseVMName,
-		"-n", runnerName,
-		"-l", vmLock.VM.GithubRunnerLabel,
+		"-b", vm.BaseVMName,
+		"-n", vm.VMInstanceName,
+		"-l", vm.GithubRunnerLabel,
 		"-t", *token.Token,
 		"-r", job.RepositoryUrl,
 	}
@@ -98,27 +160,37 @@ func (job *Job) Execute(vmLock *models.VMLock) error {
 	err = cmd.Run()
 	if err != nil {
 		fmt.Println("Error:", err)
-		return err
+		return fmt.Errorf("could not boot the VM on host %s: %w", vm.Host, err)
 	}
 	fmt.Printf("kicked off the %s script!", kickOffScript)
-	go job.kickoffWorkflowJobRun()
+	job.kickoffWorkflowJobRun()
+	go checks.Booted(job.WorkflowJobId, job.RepositoryInternalId, vm)
+	go core.PublishRunChange(job.WorkflowJobId, job.RepositoryInternalId)
 
 	return nil
 }
 
-func (job *Job) createWorkflowJobRun() {
+func (job *Job) createWorkflowJobRun() error {
 	result := models.CreateWorkflowJobRun(job.WorkflowJobId,
 		job.WorkflowJobName,
 		job.WorkflowJobUrl,
 		job.WorkflowRunId,
+		job.WorkflowRunAttempt,
 		job.WorkflowRunName,
 		job.WorkflowRunStatus,
+		job.HeadSha,
+		job.HeadBranch,
+		job.RunnerLabels,
+		job.Priority,
 		job.RepositoryInternalId,
 		job.WorkflowJobStart)
 
 	if result.Error != nil {
 		fmt.Println("could not create a workflow job run: ", result.Error)
+		return result.Error
 	}
+
+	return nil
 }
 
 func (job *Job) kickoffWorkflowJobRun() {
@@ -0,0 +1,17 @@
+package jobs
+
+import (
+	"buildkansen/internal/core"
+	"context"
+	"time"
+)
+
+const queueMonitorInterval = time.Minute
+
+// startQueueMonitor periodically escalates stalled jobs and enforces timeouts
+func startQueueMonitor(ctx context.Context) {
+	every(ctx, queueMonitorInterval, func() {
+		core.EscalateStalledJobs()
+		core.EnforceTimeouts()
+	})
+}
@@ -0,0 +1,14 @@
+package jobs
+
+import (
+	"buildkansen/internal/core"
+	"context"
+	"time"
+)
+
+const retentionPurgeInterval = time.Hour
+
+// startRetentionPurger periodically purges deleted accounts past their grace period and runs past retention
+func startRetentionPurger(ctx context.Context) {
+	every(ctx, retentionPurgeInterval, core.PurgeExpiredData)
+}
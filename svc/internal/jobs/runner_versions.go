@@ -0,0 +1,14 @@
+package jobs
+
+import (
+	"buildkansen/internal/core"
+	"context"
+	"time"
+)
+
+const runnerVersionCheckInterval = time.Hour
+
+// startRunnerVersionChecker periodically re-checks the runner versions of our images against GitHub's releases
+func startRunnerVersionChecker(ctx context.Context) {
+	every(ctx, runnerVersionCheckInterval, core.CheckRunnerVersions)
+}
@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"buildkansen/config"
+	"buildkansen/internal/priority"
+	"buildkansen/models"
+	"time"
+)
+
+// scheduler orders one pass over the queue by priority, fair share and age, holding back capped runs
+type scheduler struct {
+	queues         map[int64][]*models.WorkflowJobRun
+	installations  map[int64]models.Installation
+	byInstallation map[int64]int
+	byRepository   map[int64]int
+	aging          time.Duration
+}
+
+// newScheduler takes the pending runs oldest first, with their repository and installation loaded
+func newScheduler(jobRuns []models.WorkflowJobRun, byInstallation map[int64]int, byRepository map[int64]int) *scheduler {
+	s := &scheduler{
+		queues:         make(map[int64][]*models.WorkflowJobRun),
+		installations:  make(map[int64]models.Installation),
+		byInstallation: byInstallation,
+		byRepository:   byRepository,
+		aging:          time.Duration(config.C.PriorityAgingMinutes) * time.Minute,
+	}
+
+	for i := range jobRuns {
+		installation := jobRuns[i].Repository.Installation
+		s.queues[installation.InternalId] = append(s.queues[installation.InternalId], &jobRuns[i])
+		s.installations[installation.InternalId] = installation
+	}
+
+	return s
+}
+
+// next pops the run to try placing next, or nil once nothing else can be scheduled in this pass
+func (s *scheduler) next() *models.WorkflowJobRun {
+	var chosen int64
+	var chosenIndex, chosenPriority int
+	var chosenShare float64
+	found := false
+
+	for installationId, queue := range s.queues {
+		installation := s.installations[installationId]
+		if installation.MaxConcurrentJobs > 0 && s.byInstallation[installationId] >= installation.MaxConcurrentJobs {
+			continue
+		}
+
+		index := s.firstEligible(queue)
+		if index < 0 {
+			continue
+		}
+
+		jobPriority := s.effectivePriority(queue[index])
+		share := float64(s.byInstallation[installationId]) / float64(weight(installation))
+		if !found || s.before(jobPriority, share, queue[index], chosenPriority, chosenShare, s.queues[chosen][chosenIndex]) {
+			chosen, chosenIndex, chosenPriority, chosenShare, found = installationId, index, jobPriority, share, true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	queue := s.queues[chosen]
+	jobRun := queue[chosenIndex]
+	s.queues[chosen] = append(queue[:chosenIndex:chosenIndex], queue[chosenIndex+1:]...)
+
+	return jobRun
+}
+
+// started counts a run that got a VM against its installation and repository for the rest of the pass
+func (s *scheduler) started(jobRun *models.WorkflowJobRun) {
+	s.byInstallation[jobRun.Repository.InstallationId]++
+	s.byRepository[jobRun.RepositoryId]++
+}
+
+// firstEligible is the index of the installation's highest priority, oldest run whose repository is under its cap
+func (s *scheduler) firstEligible(queue []*models.WorkflowJobRun) int {
+	best, bestPriority := -1, 0
+
+	for i, jobRun := range queue {
+		repository := jobRun.Repository
+		if repository.MaxConcurrentJobs > 0 && s.byRepository[repository.InternalId] >= repository.MaxConcurrentJobs {
+			continue
+		}
+
+		jobPriority := s.effectivePriority(jobRun)
+		if best < 0 || jobPriority > bestPriority || (jobPriority == bestPriority && jobRun.StartedAt.Before(queue[best].StartedAt)) {
+			best, bestPriority = i, jobPriority
+		}
+	}
+
+	return best
+}
+
+func (s *scheduler) effectivePriority(jobRun *models.WorkflowJobRun) int {
+	return priority.Effective(jobRun.Priority, time.Since(jobRun.StartedAt), s.aging)
+}
+
+func (s *scheduler) before(p int, share float64, jobRun *models.WorkflowJobRun, otherP int, otherShare float64, other *models.WorkflowJobRun) bool {
+	if p != otherP {
+		return p > otherP
+	}
+
+	if share != otherShare {
+		return share < otherShare
+	}
+
+	return jobRun.StartedAt.Before(other.StartedAt)
+}
+
+func weight(installation models.Installation) int {
+	if installation.SchedulingWeight < 1 {
+		return 1
+	}
+
+	return installation.SchedulingWeight
+}
@@ -0,0 +1,134 @@
+package jobs
+
+import (
+	"buildkansen/config"
+	"buildkansen/internal/priority"
+	"buildkansen/models"
+	"testing"
+	"time"
+)
+
+func init() {
+	config.C = &config.AppConfig{PriorityAgingMinutes: 15}
+}
+
+type installationSpec struct {
+	id        int64
+	weight    int
+	maxJobs   int
+	repoLimit int
+}
+
+func queuedRun(id int64, spec installationSpec, repositoryId int64, jobPriority int, queuedFor time.Duration) models.WorkflowJobRun {
+	installation := models.Installation{InternalId: spec.id, SchedulingWeight: spec.weight, MaxConcurrentJobs: spec.maxJobs}
+	repository := models.Repository{InternalId: repositoryId, InstallationId: spec.id, MaxConcurrentJobs: spec.repoLimit, Installation: installation}
+
+	return models.WorkflowJobRun{
+		Id:           id,
+		RepositoryId: repositoryId,
+		Repository:   repository,
+		Priority:     jobPriority,
+		StartedAt:    time.Now().Add(-queuedFor),
+	}
+}
+
+// order drains the scheduler, counting every run it hands out as started
+func order(s *scheduler) []int64 {
+	ids := make([]int64, 0)
+	for jobRun := s.next(); jobRun != nil; jobRun = s.next() {
+		ids = append(ids, jobRun.Id)
+		s.started(jobRun)
+	}
+
+	return ids
+}
+
+func assertOrder(t *testing.T, got []int64, want []int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSchedulerOldestFirst(t *testing.T) {
+	a := installationSpec{id: 1, weight: 1}
+	runs := []models.WorkflowJobRun{
+		queuedRun(1, a, 10, priority.Normal, 2*time.Minute),
+		queuedRun(2, a, 10, priority.Normal, 5*time.Minute),
+		queuedRun(3, a, 10, priority.Normal, time.Minute),
+	}
+
+	assertOrder(t, order(newScheduler(runs, map[int64]int{}, map[int64]int{})), []int64{2, 1, 3})
+}
+
+func TestSchedulerPriorityFirst(t *testing.T) {
+	a := installationSpec{id: 1, weight: 1}
+	b := installationSpec{id: 2, weight: 1}
+	runs := []models.WorkflowJobRun{
+		queuedRun(1, a, 10, priority.Normal, 5*time.Minute),
+		queuedRun(2, b, 20, priority.High, time.Minute),
+		queuedRun(3, a, 10, priority.Low, 10*time.Minute),
+	}
+
+	assertOrder(t, order(newScheduler(runs, map[int64]int{}, map[int64]int{})), []int64{2, 1, 3})
+}
+
+func TestSchedulerAgesLowPriority(t *testing.T) {
+	a := installationSpec{id: 1, weight: 1}
+	runs := []models.WorkflowJobRun{
+		queuedRun(1, a, 10, priority.Normal, time.Minute),
+		// waited two aging intervals, from low to high
+		queuedRun(2, a, 10, priority.Low, 31*time.Minute),
+	}
+
+	assertOrder(t, order(newScheduler(runs, map[int64]int{}, map[int64]int{})), []int64{2, 1})
+}
+
+func TestSchedulerFairShare(t *testing.T) {
+	busy := installationSpec{id: 1, weight: 1}
+	idle := installationSpec{id: 2, weight: 1}
+	runs := []models.WorkflowJobRun{
+		queuedRun(1, busy, 10, priority.Normal, 10*time.Minute),
+		queuedRun(2, busy, 10, priority.Normal, 9*time.Minute),
+		queuedRun(3, idle, 20, priority.Normal, time.Minute),
+	}
+
+	// busy already holds two VMs, so idle's newer job goes first
+	s := newScheduler(runs, map[int64]int{1: 2}, map[int64]int{10: 2})
+	assertOrder(t, order(s), []int64{3, 1, 2})
+}
+
+func TestSchedulerWeights(t *testing.T) {
+	heavy := installationSpec{id: 1, weight: 3}
+	light := installationSpec{id: 2, weight: 1}
+	runs := []models.WorkflowJobRun{
+		queuedRun(1, heavy, 10, priority.Normal, 10*time.Minute),
+		queuedRun(2, light, 20, priority.Normal, 9*time.Minute),
+		queuedRun(3, heavy, 10, priority.Normal, 8*time.Minute),
+	}
+
+	// both hold two VMs, but heavy's share of its weight is smaller
+	s := newScheduler(runs, map[int64]int{1: 2, 2: 2}, map[int64]int{10: 2, 20: 2})
+	assertOrder(t, order(s), []int64{1, 3, 2})
+}
+
+func TestSchedulerCaps(t *testing.T) {
+	capped := installationSpec{id: 1, weight: 1, maxJobs: 2}
+	repoCapped := installationSpec{id: 2, weight: 1, repoLimit: 1}
+	runs := []models.WorkflowJobRun{
+		queuedRun(1, capped, 10, priority.Normal, 10*time.Minute),
+		queuedRun(2, capped, 10, priority.Normal, 9*time.Minute),
+		queuedRun(3, repoCapped, 20, priority.Normal, 8*time.Minute),
+		queuedRun(4, repoCapped, 20, priority.Normal, 7*time.Minute),
+		queuedRun(5, repoCapped, 21, priority.Normal, 6*time.Minute),
+	}
+
+	// capped holds one VM, so it gets one more. repoCapped's repository 20 takes one at a time
+	s := newScheduler(runs, map[int64]int{1: 1}, map[int64]int{10: 1})
+	assertOrder(t, order(s), []int64{3, 1, 5})
+}
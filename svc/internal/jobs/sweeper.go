@@ -0,0 +1,14 @@
+package jobs
+
+import (
+	"buildkansen/internal/core"
+	"context"
+	"time"
+)
+
+const runnerSweepInterval = time.Minute * 15
+
+// startRunnerSweeper periodically clears runner registrations left behind by guests that died before picking up a job
+func startRunnerSweeper(ctx context.Context) {
+	every(ctx, runnerSweepInterval, core.SweepStaleRunners)
+}
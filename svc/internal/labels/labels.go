@@ -0,0 +1,75 @@
+package labels
+
+import (
+	"sort"
+	"strings"
+)
+
+const separator = ","
+
+// runnerDefaults are the labels GitHub attaches to every self-hosted macOS runner we register
+var runnerDefaults = []string{"self-hosted", "macos", "arm64"}
+
+// Parse splits a comma-separated label list, normalising case and dropping blanks and duplicates
+func Parse(s string) []string {
+	return Normalize(strings.Split(s, separator))
+}
+
+// Normalize lowercases, dedupes and sorts labels, GitHub matches runner labels case-insensitively
+func Normalize(labels []string) []string {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(labels))
+
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if len(label) == 0 || seen[label] {
+			continue
+		}
+
+		seen[label] = true
+		normalized = append(normalized, label)
+	}
+
+	sort.Strings(normalized)
+	return normalized
+}
+
+func Join(labels []string) string {
+	return strings.Join(Normalize(labels), separator)
+}
+
+// Satisfies is true when a runner with runnerLabels can take a job that runs-on all of jobLabels
+func Satisfies(runnerLabels []string, jobLabels []string) bool {
+	available := make(map[string]bool)
+	for _, label := range append(Normalize(runnerLabels), runnerDefaults...) {
+		available[label] = true
+	}
+
+	for _, label := range Normalize(jobLabels) {
+		if !available[label] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Claims is true when at least one of jobLabels is one of ours, as opposed to only GitHub's defaults
+func Claims(known []string, jobLabels []string) bool {
+	ours := make(map[string]bool)
+	for _, label := range Normalize(known) {
+		ours[label] = true
+	}
+
+	for _, label := range runnerDefaults {
+		delete(ours, label)
+	}
+
+	for _, label := range Normalize(jobLabels) {
+		if ours[label] {
+			return true
+		}
+	}
+
+	return false
+}
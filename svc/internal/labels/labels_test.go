@@ -0,0 +1,58 @@
+package labels
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	got := Parse(" macOS-14 ,xcode-15,, MACOS-14")
+	want := []string{"macos-14", "xcode-15"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %v, want %v", got, want)
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	runner := []string{"macos-14", "xcode-15"}
+	cases := []struct {
+		name string
+		job  []string
+		want bool
+	}{
+		{"exact", []string{"macos-14", "xcode-15"}, true},
+		{"subset", []string{"macos-14"}, true},
+		{"with defaults", []string{"self-hosted", "macOS", "ARM64", "macos-14"}, true},
+		{"case insensitive", []string{"MacOS-14"}, true},
+		{"only defaults", []string{"self-hosted"}, true},
+		{"missing label", []string{"macos-14", "xcode-16"}, false},
+		{"other image", []string{"macos-13"}, false},
+	}
+
+	for _, c := range cases {
+		if got := Satisfies(runner, c.job); got != c.want {
+			t.Errorf("%s: Satisfies(%v, %v) = %v, want %v", c.name, runner, c.job, got, c.want)
+		}
+	}
+}
+
+func TestClaims(t *testing.T) {
+	known := []string{"macos-14", "xcode-15", "self-hosted"}
+	cases := []struct {
+		name string
+		job  []string
+		want bool
+	}{
+		{"one of ours", []string{"self-hosted", "macos-14"}, true},
+		{"case insensitive", []string{"XCODE-15"}, true},
+		{"only defaults", []string{"self-hosted", "macos", "arm64"}, false},
+		{"someone else's", []string{"self-hosted", "linux"}, false},
+		{"none", nil, false},
+	}
+
+	for _, c := range cases {
+		if got := Claims(known, c.job); got != c.want {
+			t.Errorf("%s: Claims(%v) = %v, want %v", c.name, c.job, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package leader
+
+import (
+	"buildkansen/db"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// lockNamespace keeps our leadership locks apart from the other advisory locks in the database
+const lockNamespace = 4270047
+
+const (
+	// campaignInterval is how often a process that isn't the leader tries to take over
+	campaignInterval = 5 * time.Second
+	// heartbeatInterval is how often the leader checks it still holds the lock
+	heartbeatInterval = 5 * time.Second
+	heartbeatTimeout  = 3 * time.Second
+)
+
+// Campaign runs lead while this process holds the named leadership, it never returns
+func Campaign(name string, lead func(ctx context.Context)) {
+	for {
+		conn, err := acquire(name)
+		if err != nil {
+			fmt.Printf("could not campaign for %s: %s\n", name, err)
+		}
+		if conn == nil {
+			time.Sleep(campaignInterval)
+			continue
+		}
+
+		fmt.Printf("leading %s\n", name)
+		hold(name, conn, lead)
+		fmt.Printf("stopped leading %s\n", name)
+	}
+}
+
+// acquire takes the leadership lock on a connection of its own, or returns nil when another process holds it
+func acquire(name string) (*sql.Conn, error) {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), heartbeatTimeout)
+	defer cancel()
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2))", lockNamespace, name).Scan(&acquired)
+	if err != nil || !acquired {
+		discard(conn)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// hold runs lead until it returns or the lock's connection stops answering, then gives the lock up
+func hold(name string, conn *sql.Conn, lead func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(ctx)
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-done:
+			discard(conn)
+			return
+		case <-heartbeat.C:
+			pingCtx, pingCancel := context.WithTimeout(context.Background(), heartbeatTimeout)
+			err := conn.PingContext(pingCtx)
+			pingCancel()
+			if err != nil {
+				fmt.Printf("lost the connection holding %s: %s\n", name, err)
+				cancel()
+				<-done
+				discard(conn)
+				return
+			}
+		}
+	}
+}
+
+// discard closes the connection instead of returning it to the pool
+func discard(conn *sql.Conn) {
+	_ = conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+	_ = conn.Close()
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// gauge is a prometheus gauge, one value per distinct set of labels
+type gauge struct {
+	help   string
+	values map[string]float64
+}
+
+var (
+	mu     sync.Mutex
+	gauges = make(map[string]*gauge)
+)
+
+func SetGauge(name string, help string, labels map[string]string, value float64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := gauges[name]
+	if !ok {
+		g = &gauge{help: help, values: make(map[string]float64)}
+		gauges[name] = g
+	}
+
+	g.values[formatLabels(labels)] = value
+}
+
+// ResetGauge drops every value of the gauge, for gauges that are recomputed from scratch
+func ResetGauge(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if g, ok := gauges[name]; ok {
+		g.values = make(map[string]float64)
+	}
+}
+
+// Write renders every gauge in the prometheus text exposition format
+func Write(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		g := gauges[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, g.help, name); err != nil {
+			return err
+		}
+
+		series := make([]string, 0, len(g.values))
+		for labels := range g.values {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+
+		for _, labels := range series {
+			if _, err := fmt.Fprintf(w, "%s%s %g\n", name, labels, g.values[labels]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[key])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,156 @@
+package priority
+
+import (
+	"buildkansen/config"
+	"buildkansen/internal/labels"
+	"buildkansen/log"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	Low    = 0
+	Normal = 1
+	High   = 2
+)
+
+var classes = map[string]int{"low": Low, "normal": Normal, "high": High}
+
+// Rule assigns a priority class to jobs matching all of its non-empty path.Match patterns
+type Rule struct {
+	Priority      string `json:"priority"`
+	WorkflowName  string `json:"workflow_name"`
+	Branch        string `json:"branch"`
+	DefaultBranch bool   `json:"default_branch"`
+	Repository    string `json:"repository"`
+	Label         string `json:"label"`
+}
+
+// Attributes are what the rules match against, from the workflow_job webhook
+type Attributes struct {
+	WorkflowName  string
+	Branch        string
+	DefaultBranch string
+	Repository    string
+	Labels        []string
+}
+
+// defaultRules put releases and default branch builds ahead of PR builds
+var defaultRules = []Rule{
+	{Priority: "high", WorkflowName: "Release*"},
+	{Priority: "high", DefaultBranch: true},
+}
+
+var rules = defaultRules
+
+func Init() {
+	if err := Load(config.C.PriorityRulesPath); err != nil {
+		log.Fatalf("Error loading the priority rules")
+		panic(err)
+	}
+}
+
+// Load reads the rules from a JSON file holding a list of rules, the defaults are used if path is empty
+func Load(rulesPath string) error {
+	if len(rulesPath) == 0 {
+		rules = defaultRules
+		return nil
+	}
+
+	body, err := os.ReadFile(rulesPath)
+	if err != nil {
+		return err
+	}
+
+	loaded := make([]Rule, 0)
+	if err := json.Unmarshal(body, &loaded); err != nil {
+		return err
+	}
+
+	for _, rule := range loaded {
+		if _, ok := classes[rule.Priority]; !ok {
+			return fmt.Errorf("unknown priority %q, expected low, normal or high", rule.Priority)
+		}
+	}
+
+	rules = loaded
+	return nil
+}
+
+// Compute is the class of the first rule the job matches, normal if none do
+func Compute(attributes Attributes) int {
+	for _, rule := range rules {
+		if rule.matches(attributes) {
+			return classes[rule.Priority]
+		}
+	}
+
+	return Normal
+}
+
+// Effective ages a job up a class for every agingInterval it has waited, so low priority work is not starved
+func Effective(priority int, queuedFor time.Duration, agingInterval time.Duration) int {
+	if agingInterval <= 0 || queuedFor <= 0 {
+		return priority
+	}
+
+	effective := priority + int(queuedFor/agingInterval)
+	if effective > High {
+		return High
+	}
+
+	return effective
+}
+
+func Name(priority int) string {
+	for name, class := range classes {
+		if class == priority {
+			return name
+		}
+	}
+
+	return "normal"
+}
+
+func (r Rule) matches(a Attributes) bool {
+	if len(r.WorkflowName) > 0 && !glob(r.WorkflowName, a.WorkflowName) {
+		return false
+	}
+
+	if len(r.Branch) > 0 && !glob(r.Branch, a.Branch) {
+		return false
+	}
+
+	if r.DefaultBranch && (len(a.Branch) == 0 || a.Branch != a.DefaultBranch) {
+		return false
+	}
+
+	if len(r.Repository) > 0 && !glob(strings.ToLower(r.Repository), strings.ToLower(a.Repository)) {
+		return false
+	}
+
+	if len(r.Label) > 0 && !hasLabel(a.Labels, r.Label) {
+		return false
+	}
+
+	return true
+}
+
+func hasLabel(jobLabels []string, label string) bool {
+	for _, l := range labels.Normalize(jobLabels) {
+		if l == strings.ToLower(label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func glob(pattern string, value string) bool {
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
@@ -0,0 +1,98 @@
+package priority
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputeDefaults(t *testing.T) {
+	if err := Load(""); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		attributes Attributes
+		want       int
+	}{
+		{"release workflow", Attributes{WorkflowName: "Release iOS", Branch: "feature"}, High},
+		{"default branch", Attributes{WorkflowName: "CI", Branch: "main", DefaultBranch: "main"}, High},
+		{"pull request", Attributes{WorkflowName: "CI", Branch: "feature", DefaultBranch: "main"}, Normal},
+		{"no branch", Attributes{WorkflowName: "CI"}, Normal},
+	}
+
+	for _, c := range cases {
+		if got := Compute(c.attributes); got != c.want {
+			t.Errorf("%s: Compute = %s, want %s", c.name, Name(got), Name(c.want))
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	defer Load("")
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `[
+		{"priority": "low", "repository": "Tramlinehq/*", "label": "XCODE-15"},
+		{"priority": "high", "branch": "release/*"}
+	]`
+	if err := os.WriteFile(path, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path); err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+
+	low := Attributes{Repository: "tramlinehq/ueno", Labels: []string{"xcode-15"}, Branch: "release/1.0"}
+	if got := Compute(low); got != Low {
+		t.Errorf("the first matching rule wins, got %s", Name(got))
+	}
+
+	high := Attributes{Repository: "tramlinehq/ueno", Labels: []string{"xcode-16"}, Branch: "release/1.0"}
+	if got := Compute(high); got != High {
+		t.Errorf("a rule matches only when all its fields do, got %s", Name(got))
+	}
+
+	if got := Compute(Attributes{WorkflowName: "Release"}); got != Normal {
+		t.Errorf("loaded rules replace the defaults, got %s", Name(got))
+	}
+}
+
+func TestLoadUnknownPriority(t *testing.T) {
+	defer Load("")
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`[{"priority": "urgent"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Load(path); err == nil {
+		t.Error("Load should refuse an unknown priority")
+	}
+}
+
+func TestEffective(t *testing.T) {
+	aging := 15 * time.Minute
+	cases := []struct {
+		priority  int
+		queuedFor time.Duration
+		want      int
+	}{
+		{Low, 10 * time.Minute, Low},
+		{Low, 20 * time.Minute, Normal},
+		{Low, time.Hour, High},
+		{High, time.Hour, High},
+	}
+
+	for _, c := range cases {
+		if got := Effective(c.priority, c.queuedFor, aging); got != c.want {
+			t.Errorf("Effective(%s, %s) = %s, want %s", Name(c.priority), c.queuedFor, Name(got), Name(c.want))
+		}
+	}
+
+	if got := Effective(Low, time.Hour, 0); got != Low {
+		t.Errorf("no aging interval should not age, got %s", Name(got))
+	}
+}
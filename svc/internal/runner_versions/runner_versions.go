@@ -0,0 +1,130 @@
+package runner_versions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Release is an actions/runner release, as listed by the GitHub releases API
+type Release struct {
+	TagName     string    `json:"tag_name"`
+	PublishedAt time.Time `json:"published_at"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+}
+
+func (r Release) Version() string {
+	return strings.TrimPrefix(r.TagName, "v")
+}
+
+// FetchReleases reads the runner releases from the GitHub API, a mirror of it, or a file:// fixture
+func FetchReleases(releasesUrl string) ([]Release, error) {
+	u, err := url.Parse(releasesUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if u.Scheme == "file" {
+		body, err = os.ReadFile(u.Path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		response, err := http.Get(releasesUrl)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching runner releases returned %d", response.StatusCode)
+		}
+
+		body, err = io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	releases := make([]Release, 0)
+	if err := json.Unmarshal(body, &releases); err != nil {
+		return nil, err
+	}
+
+	published := make([]Release, 0, len(releases))
+	for _, release := range releases {
+		if !release.Draft && !release.Prerelease {
+			published = append(published, release)
+		}
+	}
+
+	return published, nil
+}
+
+// Latest is the newest published release
+func Latest(releases []Release) (Release, bool) {
+	var latest Release
+	found := false
+
+	for _, release := range releases {
+		if !found || Compare(release.Version(), latest.Version()) > 0 {
+			latest, found = release, true
+		}
+	}
+
+	return latest, found
+}
+
+// Deadline is when GitHub stops sending jobs to a runner on version, false while it is the latest
+func Deadline(version string, releases []Release, grace time.Duration) (time.Time, bool) {
+	var superseded time.Time
+	found := false
+
+	for _, release := range releases {
+		if Compare(release.Version(), version) <= 0 {
+			continue
+		}
+
+		if !found || release.PublishedAt.Before(superseded) {
+			superseded, found = release.PublishedAt, true
+		}
+	}
+
+	if !found {
+		return time.Time{}, false
+	}
+
+	return superseded.Add(grace), true
+}
+
+// Compare compares dotted numeric versions, like 2.313.0, returning -1, 0 or 1
+func Compare(a string, b string) int {
+	as, bs := strings.Split(strings.TrimPrefix(a, "v"), "."), strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+
+	return 0
+}
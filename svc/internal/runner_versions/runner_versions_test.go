@@ -0,0 +1,116 @@
+package runner_versions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"2.313.0", "2.313.0", 0},
+		{"2.313.0", "2.312.0", 1},
+		{"2.312.0", "2.313.0", -1},
+		{"2.313.0", "2.99.0", 1},
+		{"v2.313.0", "2.313.0", 0},
+		{"2.313", "2.313.0", 0},
+		{"2.313.1", "2.313", 1},
+		{"3.0.0", "2.999.999", 1},
+	}
+
+	for _, c := range cases {
+		if got := Compare(c.a, c.b); got != c.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestFetchReleasesFromFile(t *testing.T) {
+	path, err := filepath.Abs("testdata/releases.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releases, err := FetchReleases("file://" + path)
+	if err != nil {
+		t.Fatalf("FetchReleases: %s", err)
+	}
+
+	if len(releases) != 3 {
+		t.Fatalf("got %d releases, want the 3 published ones", len(releases))
+	}
+	for _, release := range releases {
+		if release.Version() == "2.314.0" || release.Version() == "2.315.0" {
+			t.Errorf("got %s, drafts and prereleases should be skipped", release.TagName)
+		}
+	}
+}
+
+func TestFetchReleasesFromServer(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/releases.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(fixture)
+	}))
+	defer server.Close()
+
+	releases, err := FetchReleases(server.URL)
+	if err != nil {
+		t.Fatalf("FetchReleases: %s", err)
+	}
+
+	latest, found := Latest(releases)
+	if !found || latest.Version() != "2.313.0" {
+		t.Errorf("Latest = %q, %v, want 2.313.0", latest.Version(), found)
+	}
+}
+
+func TestFetchReleasesFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	if _, err := FetchReleases(server.URL); err == nil {
+		t.Error("FetchReleases should fail when the API does not answer 200")
+	}
+}
+
+func TestLatestWithoutReleases(t *testing.T) {
+	if _, found := Latest(nil); found {
+		t.Error("Latest should find nothing without releases")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	releases := []Release{
+		{TagName: "v2.313.0", PublishedAt: time.Date(2024, 2, 15, 10, 0, 0, 0, time.UTC)},
+		{TagName: "v2.312.0", PublishedAt: time.Date(2024, 1, 20, 10, 0, 0, 0, time.UTC)},
+		{TagName: "v2.311.0", PublishedAt: time.Date(2023, 11, 10, 10, 0, 0, 0, time.UTC)},
+	}
+	grace := 30 * 24 * time.Hour
+
+	// superseded by 2.312.0, the first release newer than it, not by the latest
+	deadline, found := Deadline("2.311.0", releases, grace)
+	want := time.Date(2024, 2, 19, 10, 0, 0, 0, time.UTC)
+	if !found || !deadline.Equal(want) {
+		t.Errorf("Deadline(2.311.0) = %s, %v, want %s", deadline, found, want)
+	}
+
+	if _, found := Deadline("2.313.0", releases, grace); found {
+		t.Error("the latest version should have no deadline")
+	}
+
+	if _, found := Deadline("2.400.0", releases, grace); found {
+		t.Error("a version newer than every release should have no deadline")
+	}
+}
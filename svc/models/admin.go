@@ -0,0 +1,160 @@
+package models
+
+import (
+	"buildkansen/db"
+	"database/sql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// Host is a mac machine whose VMs are bound to the pool, a drained host's VMs get no new jobs
+type Host struct {
+	Id        int64  `gorm:"primaryKey"`
+	Name      string `gorm:"uniqueIndex"`
+	Drained   bool
+	DrainedAt sql.NullTime
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// HostStatus is how many of a host's VMs are free and busy, and whether it is drained
+type HostStatus struct {
+	Host      string
+	Available int64
+	Busy      int64
+	Drained   bool
+}
+
+func FetchHostStatuses() ([]HostStatus, error) {
+	hosts := make([]HostStatus, 0)
+	result := db.DB.Model(&VM{}).
+		Select("vms.host, "+
+			"count(*) FILTER (WHERE vms.status = ?) AS available, "+
+			"count(*) FILTER (WHERE vms.status <> ?) AS busy, "+
+			"coalesce(bool_or(hosts.drained), false) AS drained", VMAvailable, VMAvailable).
+		Joins("LEFT JOIN hosts ON hosts.name = vms.host").
+		Group("vms.host").
+		Order("vms.host").
+		Scan(&hosts)
+
+	return hosts, result.Error
+}
+
+func SetHostDrained(name string, drained bool) *gorm.DB {
+	host := Host{Name: name, Drained: drained, DrainedAt: sql.NullTime{Time: time.Now(), Valid: drained}}
+	return db.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"drained", "drained_at", "updated_at"}),
+	}).Create(&host)
+}
+
+// drainedHosts is a subquery of the names of the drained hosts
+func drainedHosts() *gorm.DB {
+	return db.DB.Model(&Host{}).Select("name").Where("drained")
+}
+
+func FindVM(id int64) (*VM, error) {
+	vm := VM{}
+	result := db.DB.First(&vm, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &vm, nil
+}
+
+// FindVMByInstanceName finds the VM a run is on, if it still holds the run's guest
+func FindVMByInstanceName(instanceName string) (*VM, error) {
+	vm := VM{}
+	result := db.DB.Where("vm_instance_name = ?", instanceName).First(&vm)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &vm, nil
+}
+
+// OpenRunsByVMInstance maps the guest names of the VMs to the runs on them that have not ended
+func OpenRunsByVMInstance() (map[string]WorkflowJobRun, error) {
+	jobRuns := make([]WorkflowJobRun, 0)
+	result := db.DB.
+		Preload("Repository").
+		Where("vm_instance_name <> '' AND ended_at IS NULL").
+		Find(&jobRuns)
+
+	byInstance := make(map[string]WorkflowJobRun, len(jobRuns))
+	for _, jobRun := range jobRuns {
+		byInstance[jobRun.VMInstanceName] = jobRun
+	}
+
+	return byInstance, result.Error
+}
+
+// FindAnyRun finds a run of any installation, with its repository and installation, for operators
+func FindAnyRun(internalId int64) (*WorkflowJobRun, error) {
+	jobRun := WorkflowJobRun{}
+	result := db.DB.Preload("Repository.Installation").First(&jobRun, internalId)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &jobRun, nil
+}
+
+// RequeueWorkflowJobRun puts a run that never started on its runner back in the queue, as if it was just queued
+func RequeueWorkflowJobRun(tx *gorm.DB, id int64, repositoryId int64) *gorm.DB {
+	updates := map[string]interface{}{
+		"status":           "queued",
+		"conclusion":       gorm.Expr("NULL"),
+		"failure_reason":   gorm.Expr("NULL"),
+		"vm_instance_name": "",
+		"vm_host":          "",
+		"assigned_at":      gorm.Expr("NULL"),
+		"kickoff_at":       gorm.Expr("NULL"),
+		"escalated_at":     gorm.Expr("NULL"),
+	}
+
+	return tx.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ? AND processing_at IS NULL AND ended_at IS NULL", id, repositoryId).
+		Updates(updates)
+}
+
+// StrandedWorkflowJobRuns are the runs we failed to start in the last day
+func StrandedWorkflowJobRuns() ([]WorkflowJobRun, error) {
+	jobRuns := make([]WorkflowJobRun, 0)
+	result := db.DB.
+		Preload("Repository.Installation").
+		Where("failure_reason IS NOT NULL AND processing_at IS NULL AND ended_at IS NULL AND started_at > ?", time.Now().Add(-24*time.Hour)).
+		Order("started_at DESC").
+		Find(&jobRuns)
+
+	return jobRuns, result.Error
+}
+
+// OrphanedVMs are the VMs waiting to be torn down since before the cutoff
+func OrphanedVMs(cutoff time.Time) ([]VM, error) {
+	vms := make([]VM, 0)
+	result := db.DB.
+		Where("updated_at < ?", cutoff).
+		Where("status = ? OR (status = ? AND (workflow_job_run_id IS NULL OR workflow_job_run_id IN (?)))",
+			VMPurging, VMProcessing, db.DB.Model(&WorkflowJobRun{}).Select("internal_id").Where("ended_at IS NOT NULL")).
+		Order("id ASC").
+		Find(&vms)
+
+	return vms, result.Error
+}
+
+// LostWorkflowJobRuns are the runs kicked off before the cutoff that no VM holds and that have not ended
+func LostWorkflowJobRuns(cutoff time.Time) ([]WorkflowJobRun, error) {
+	jobRuns := make([]WorkflowJobRun, 0)
+	result := db.DB.
+		Preload("Repository.Installation").
+		Where("kickoff_at < ? AND ended_at IS NULL AND failure_reason IS NULL AND vm_instance_name <> ''", cutoff).
+		Where("NOT EXISTS (?)", db.DB.Model(&VM{}).Select("1").Where("vms.vm_instance_name = workflow_job_runs.vm_instance_name")).
+		Order("kickoff_at ASC").
+		Find(&jobRuns)
+
+	return jobRuns, result.Error
+}
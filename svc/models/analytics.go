@@ -0,0 +1,154 @@
+package models
+
+import (
+	"buildkansen/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AnalyticsByLabel      = "label"
+	AnalyticsByRepository = "repository"
+	AnalyticsByWorkflow   = "workflow"
+)
+
+var analyticsGroupColumns = map[string]string{
+	AnalyticsByLabel:      "workflow_job_runs.labels",
+	AnalyticsByRepository: "repositories.full_name",
+	AnalyticsByWorkflow:   "workflow_job_runs.workflow_name",
+}
+
+// DurationStats are queue and run time percentiles, in seconds, of the finished jobs in a group
+type DurationStats struct {
+	Group    string  `gorm:"column:grouping" json:"group"`
+	Jobs     int64   `json:"jobs"`
+	QueueP50 float64 `json:"queue_p50"`
+	QueueP95 float64 `json:"queue_p95"`
+	QueueP99 float64 `json:"queue_p99"`
+	RunP50   float64 `json:"run_p50"`
+	RunP95   float64 `json:"run_p95"`
+	RunP99   float64 `json:"run_p99"`
+}
+
+// HourlyJobs is how many jobs were queued in an hour
+type HourlyJobs struct {
+	Hour time.Time `json:"hour"`
+	Jobs int64     `json:"jobs"`
+}
+
+// HostUtilisation is the share of a host's VM slots that were held by jobs in an hour
+type HostUtilisation struct {
+	Host        string    `json:"host"`
+	Hour        time.Time `json:"hour"`
+	BusySeconds float64   `json:"busy_seconds"`
+	Slots       int64     `json:"slots"`
+	Utilisation float64   `json:"utilisation"`
+}
+
+// FailureRates split a group's failed jobs into infrastructure failures, timeouts and build failures
+type FailureRates struct {
+	Group                     string  `gorm:"column:grouping" json:"group"`
+	Jobs                      int64   `json:"jobs"`
+	InfrastructureFailures    int64   `json:"infrastructure_failures"`
+	TimedOut                  int64   `json:"timed_out"`
+	BuildFailures             int64   `json:"build_failures"`
+	InfrastructureFailureRate float64 `gorm:"-" json:"infrastructure_failure_rate"`
+	TimedOutRate              float64 `gorm:"-" json:"timed_out_rate"`
+	BuildFailureRate          float64 `gorm:"-" json:"build_failure_rate"`
+}
+
+// ValidAnalyticsGroup is true for the groupings the analytics can be split by
+func ValidAnalyticsGroup(group string) bool {
+	_, ok := analyticsGroupColumns[group]
+	return ok
+}
+
+// runsSince scopes runs to the installations' that were queued since the given time
+func runsSince(installationIds []int64, since time.Time) *gorm.DB {
+	return db.DB.Model(&WorkflowJobRun{}).
+		Joins("JOIN repositories ON repositories.internal_id = workflow_job_runs.repository_id").
+		Where("repositories.installation_id IN ? AND workflow_job_runs.started_at >= ?", installationIds, since)
+}
+
+// DurationPercentiles computes p50/p95/p99 queue and run times of the jobs that finished, split by group
+func DurationPercentiles(installationIds []int64, group string, since time.Time) ([]DurationStats, error) {
+	stats := make([]DurationStats, 0)
+	column := analyticsGroupColumns[group]
+	result := runsSince(installationIds, since).
+		Where("workflow_job_runs.queue_seconds IS NOT NULL AND workflow_job_runs.run_seconds IS NOT NULL").
+		Select(column + " AS grouping, count(*) AS jobs, " +
+			"percentile_cont(0.5) WITHIN GROUP (ORDER BY workflow_job_runs.queue_seconds) AS queue_p50, " +
+			"percentile_cont(0.95) WITHIN GROUP (ORDER BY workflow_job_runs.queue_seconds) AS queue_p95, " +
+			"percentile_cont(0.99) WITHIN GROUP (ORDER BY workflow_job_runs.queue_seconds) AS queue_p99, " +
+			"percentile_cont(0.5) WITHIN GROUP (ORDER BY workflow_job_runs.run_seconds) AS run_p50, " +
+			"percentile_cont(0.95) WITHIN GROUP (ORDER BY workflow_job_runs.run_seconds) AS run_p95, " +
+			"percentile_cont(0.99) WITHIN GROUP (ORDER BY workflow_job_runs.run_seconds) AS run_p99").
+		Group(column).
+		Order(column).
+		Scan(&stats)
+
+	return stats, result.Error
+}
+
+// JobsPerHour counts the jobs queued in each hour that had any
+func JobsPerHour(installationIds []int64, since time.Time) ([]HourlyJobs, error) {
+	hours := make([]HourlyJobs, 0)
+	result := runsSince(installationIds, since).
+		Select("date_trunc('hour', workflow_job_runs.started_at) AS hour, count(*) AS jobs").
+		Group("hour").
+		Order("hour").
+		Scan(&hours)
+
+	return hours, result.Error
+}
+
+// HostUtilisationByHour spreads the metered VM time of each host over the hours it was held in
+func HostUtilisationByHour(since time.Time) ([]HostUtilisation, error) {
+	hours := make([]HostUtilisation, 0)
+	result := db.DB.Raw(`
+		SELECT u.host, h.hour,
+		       sum(extract(epoch FROM least(u.ended_at, h.hour + interval '1 hour') - greatest(u.started_at, h.hour))) AS busy_seconds,
+		       coalesce(s.slots, 0) AS slots
+		FROM usage_records u
+		JOIN generate_series(date_trunc('hour', ?::timestamptz), date_trunc('hour', now()), interval '1 hour') AS h(hour)
+		  ON u.started_at < h.hour + interval '1 hour' AND u.ended_at > h.hour
+		LEFT JOIN (SELECT host, count(*) AS slots FROM vms GROUP BY host) s ON s.host = u.host
+		WHERE u.ended_at > ?
+		GROUP BY u.host, h.hour, s.slots
+		ORDER BY u.host, h.hour`, since, since).
+		Scan(&hours)
+
+	for i := range hours {
+		if hours[i].Slots > 0 {
+			hours[i].Utilisation = hours[i].BusySeconds / float64(hours[i].Slots*3600)
+		}
+	}
+
+	return hours, result.Error
+}
+
+// JobFailureRates counts the finished jobs per group that failed on our side, timed out or failed their build
+func JobFailureRates(installationIds []int64, group string, since time.Time) ([]FailureRates, error) {
+	rates := make([]FailureRates, 0)
+	column := analyticsGroupColumns[group]
+	result := runsSince(installationIds, since).
+		Where("workflow_job_runs.ended_at IS NOT NULL OR workflow_job_runs.failure_reason IS NOT NULL").
+		Select(column+" AS grouping, count(*) AS jobs, "+
+			"count(*) FILTER (WHERE workflow_job_runs.failure_reason IS NOT NULL AND workflow_job_runs.conclusion IS DISTINCT FROM ?) AS infrastructure_failures, "+
+			"count(*) FILTER (WHERE workflow_job_runs.conclusion = ?) AS timed_out, "+
+			"count(*) FILTER (WHERE workflow_job_runs.failure_reason IS NULL AND workflow_job_runs.conclusion = 'failure') AS build_failures", TimedOutConclusion, TimedOutConclusion).
+		Group(column).
+		Order(column).
+		Scan(&rates)
+
+	for i := range rates {
+		if rates[i].Jobs > 0 {
+			rates[i].InfrastructureFailureRate = float64(rates[i].InfrastructureFailures) / float64(rates[i].Jobs)
+			rates[i].TimedOutRate = float64(rates[i].TimedOut) / float64(rates[i].Jobs)
+			rates[i].BuildFailureRate = float64(rates[i].BuildFailures) / float64(rates[i].Jobs)
+		}
+	}
+
+	return rates, result.Error
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"buildkansen/db"
+	"database/sql"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ScopeRunsRead  = "runs:read"
+	ScopeRunsWrite = "runs:write"
+	ScopePoolRead  = "pool:read"
+	ScopeUsageRead = "usage:read"
+)
+
+// ApiScopes are every scope a token can be granted
+var ApiScopes = []string{ScopeRunsRead, ScopeRunsWrite, ScopePoolRead, ScopeUsageRead}
+
+// ApiToken is a user's personal access token to the public API, only its hash is kept
+type ApiToken struct {
+	Id         int64 `gorm:"primaryKey"`
+	UserId     int64 `gorm:"index"`
+	User       User  `gorm:"foreignKey:UserId;references:Id;constraint:OnDelete:CASCADE"`
+	Name       string
+	Prefix     string
+	TokenHash  string `gorm:"uniqueIndex"`
+	Scopes     string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+func (t *ApiToken) HasScope(scope string) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Active is true for a token that has been neither revoked nor has expired
+func (t *ApiToken) Active() bool {
+	if t.RevokedAt.Valid {
+		return false
+	}
+
+	return !t.ExpiresAt.Valid || t.ExpiresAt.Time.After(time.Now())
+}
+
+func CreateApiToken(token *ApiToken) *gorm.DB {
+	return db.DB.Create(token)
+}
+
+func FetchApiTokens(userId int64) ([]ApiToken, error) {
+	tokens := make([]ApiToken, 0)
+	result := db.DB.Where("user_id = ?", userId).Order("created_at DESC").Find(&tokens)
+
+	return tokens, result.Error
+}
+
+// FindApiToken finds a token by its hash, the tokens of deleted users are not found
+func FindApiToken(tokenHash string) (*ApiToken, error) {
+	token := ApiToken{}
+	result := db.DB.Preload("User").Where("token_hash = ? AND user_id IN (?)", tokenHash, activeUserIds()).First(&token)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &token, nil
+}
+
+func RevokeApiToken(userId int64, id int64) *gorm.DB {
+	return db.DB.
+		Model(&ApiToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userId).
+		Update("revoked_at", time.Now())
+}
+
+func TouchApiToken(token *ApiToken) *gorm.DB {
+	return db.DB.Model(token).Update("last_used_at", time.Now())
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"buildkansen/db"
+	"database/sql"
+	"time"
+)
+
+// AuditActorKind is who or what performed an audited action
+type AuditActorKind string
+
+const (
+	AuditActorUser        AuditActorKind = "user"
+	AuditActorApiToken    AuditActorKind = "api_token"
+	AuditActorInternalApi AuditActorKind = "internal_api"
+	// AuditActorCli is an operator on the command line, ActorLogin is their login on the machine
+	AuditActorCli AuditActorKind = "cli"
+)
+
+// AuditEvent records an administrative or destructive action, the table is append-only
+type AuditEvent struct {
+	Id        int64          `gorm:"primaryKey"`
+	ActorKind AuditActorKind `gorm:"index"`
+	// ActorId is the user's GitHub id, ActorLogin their login when they acted
+	ActorId    sql.NullInt64 `gorm:"index"`
+	ActorLogin string
+	ApiTokenId sql.NullInt64
+	Action     string `gorm:"index"`
+	TargetType string
+	TargetId   string
+	// InstallationId is the internal id of the installation the target belongs to, if any
+	InstallationId sql.NullInt64  `gorm:"index"`
+	Before         sql.NullString `gorm:"type:jsonb"`
+	After          sql.NullString `gorm:"type:jsonb"`
+	IpAddress      string
+	UserAgent      string
+	Method         string
+	Path           string
+	CreatedAt      time.Time `gorm:"autoCreateTime;index"`
+}
+
+// AuditFilter narrows the audit log, zero values do not filter
+type AuditFilter struct {
+	Action         string
+	ActorId        int64
+	InstallationId int64
+	From           time.Time
+	To             time.Time
+	Page           int
+	PerPage        int
+}
+
+func RecordAuditEvent(event *AuditEvent) error {
+	return db.DB.Create(event).Error
+}
+
+// FetchAuditEvents returns a page of the events matching the filter, newest first, and the total
+func FetchAuditEvents(filter AuditFilter) ([]AuditEvent, int64, error) {
+	events := make([]AuditEvent, 0)
+	query := db.DB.Model(&AuditEvent{})
+
+	if len(filter.Action) > 0 {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.ActorId != 0 {
+		query = query.Where("actor_id = ?", filter.ActorId)
+	}
+	if filter.InstallationId != 0 {
+		query = query.Where("installation_id = ?", filter.InstallationId)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
+	var total int64
+	if result := query.Count(&total); result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	query = query.Order("created_at DESC, id DESC")
+	if filter.PerPage > 0 {
+		query = query.Offset((filter.Page - 1) * filter.PerPage).Limit(filter.PerPage)
+	}
+
+	result := query.Find(&events)
+	return events, total, result.Error
+}
+
+func AuditActions() ([]string, error) {
+	actions := make([]string, 0)
+	result := db.DB.Model(&AuditEvent{}).Distinct().Order("action").Pluck("action", &actions)
+
+	return actions, result.Error
+}
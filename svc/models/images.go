@@ -0,0 +1,247 @@
+package models
+
+import (
+	"buildkansen/db"
+	"buildkansen/internal/labels"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Image is a macOS VM image built by packer (see pool/) that VMs are parked from
+type Image struct {
+	Id            int64  `gorm:"primaryKey"`
+	Name          string `gorm:"index:idx_uniq_image,unique"`
+	Version       string `gorm:"index:idx_uniq_image,unique"`
+	MacOSVersion  string
+	XcodeVersion  string
+	RunnerVersion string
+	// Labels are the comma-separated runner labels the image's VMs register with
+	Labels   string
+	Checksum string
+	BuiltAt  time.Time
+	// RunnerLatestVersion and RunnerDeadline are filled in by the periodic runner version check
+	RunnerLatestVersion string
+	RunnerDeadline      sql.NullTime
+	RunnerCheckedAt     sql.NullTime
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
+}
+
+// RunnerStatus is how close an image's baked-in runner is to being turned away by GitHub
+type RunnerStatus string
+
+const (
+	RunnerCurrent  RunnerStatus = "current"
+	RunnerExpiring RunnerStatus = "expiring"
+	RunnerDead     RunnerStatus = "dead"
+)
+
+// RunnerStatus is expiring once the deadline is within warnWithin, and dead after it
+func (i *Image) RunnerStatus(warnWithin time.Duration) RunnerStatus {
+	if !i.RunnerDeadline.Valid {
+		return RunnerCurrent
+	}
+
+	remaining := time.Until(i.RunnerDeadline.Time)
+	if remaining <= 0 {
+		return RunnerDead
+	}
+
+	if remaining <= warnWithin {
+		return RunnerExpiring
+	}
+
+	return RunnerCurrent
+}
+
+func (i *Image) RunnerDead() bool {
+	return i.RunnerStatus(0) == RunnerDead
+}
+
+func (i *Image) LabelSet() []string {
+	return labels.Parse(i.Labels)
+}
+
+// Rollout tracks which image serves a runner label, a canary takes CanaryPercent of its jobs
+type Rollout struct {
+	Id              int64  `gorm:"primaryKey"`
+	Label           string `gorm:"uniqueIndex"`
+	StableImageId   sql.NullInt64
+	StableImage     *Image `gorm:"foreignKey:StableImageId;references:Id"`
+	CanaryImageId   sql.NullInt64
+	CanaryImage     *Image `gorm:"foreignKey:CanaryImageId;references:Id"`
+	CanaryPercent   int
+	PreviousImageId sql.NullInt64
+	PreviousImage   *Image    `gorm:"foreignKey:PreviousImageId;references:Id"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+}
+
+// LabelRuntime is how long a label's jobs may hold a VM, labels without one use DEFAULT_MAX_RUNTIME_MINUTES
+type LabelRuntime struct {
+	Id                int64  `gorm:"primaryKey"`
+	Label             string `gorm:"uniqueIndex"`
+	MaxRuntimeMinutes int
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time `gorm:"autoUpdateTime"`
+}
+
+func UpsertImage(image *Image) *gorm.DB {
+	return db.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "name"}, {Name: "version"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"mac_os_version",
+			"xcode_version",
+			"runner_version",
+			"labels",
+			"checksum",
+			"built_at",
+			"updated_at",
+		}),
+	}).Create(image)
+}
+
+func UpdateImageRunnerVersions(image *Image, latestVersion string, deadline sql.NullTime) *gorm.DB {
+	updates := map[string]interface{}{
+		"runner_latest_version": latestVersion,
+		"runner_deadline":       deadline,
+		"runner_checked_at":     time.Now(),
+	}
+
+	return db.DB.Model(image).Updates(updates)
+}
+
+// FetchImagesInUse returns the images that have at least one VM parked from them
+func FetchImagesInUse() ([]Image, error) {
+	images := make([]Image, 0)
+	result := db.DB.
+		Where("id IN (?)", db.DB.Model(&VM{}).Distinct("image_id").Where("image_id IS NOT NULL")).
+		Order("name ASC, version ASC").
+		Find(&images)
+
+	return images, result.Error
+}
+
+func FetchImages() ([]Image, error) {
+	images := make([]Image, 0)
+	result := db.DB.Order("built_at DESC").Find(&images)
+
+	return images, result.Error
+}
+
+// FindImage looks up an image by name, and version when one is given, otherwise the latest build of that name
+func FindImage(name string, version string) (*Image, error) {
+	image := Image{}
+	query := db.DB.Where("name = ?", name)
+	if len(version) > 0 {
+		query = query.Where("version = ?", version)
+	}
+
+	result := query.Order("built_at DESC").First(&image)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &image, nil
+}
+
+func FetchRollouts() ([]Rollout, error) {
+	rollouts := make([]Rollout, 0)
+	result := db.DB.Preload(clause.Associations).Order("label ASC").Find(&rollouts)
+
+	return rollouts, result.Error
+}
+
+// FindRollout returns the rollout for label, or an unsaved one if the label has never had an image promoted to it
+func FindRollout(label string) (*Rollout, error) {
+	rollout := Rollout{}
+	result := db.DB.Where("label = ?", label).Limit(1).Find(&rollout)
+	if result.RowsAffected == 0 {
+		rollout.Label = label
+	}
+
+	return &rollout, result.Error
+}
+
+func SaveRollout(rollout *Rollout) *gorm.DB {
+	return db.DB.Save(rollout)
+}
+
+// RolloutFor picks the rollout governing a job, that of the first of its labels to have one
+func RolloutFor(rollouts []Rollout, jobLabels []string) *Rollout {
+	for _, label := range labels.Normalize(jobLabels) {
+		for i := range rollouts {
+			if rollouts[i].Label == label {
+				return &rollouts[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// Serves is true when a VM parked from imageId may run this rollout's jobs
+func (r *Rollout) Serves(imageId sql.NullInt64) bool {
+	if !r.StableImageId.Valid {
+		return true
+	}
+
+	return imageId == r.StableImageId || imageId == r.CanaryImageId
+}
+
+// Prefers is true when imageId is the image this job should land on
+func (r *Rollout) Prefers(imageId sql.NullInt64, jobId int64) bool {
+	if r.canary(jobId) {
+		return imageId == r.CanaryImageId
+	}
+
+	if r.CanaryImageId.Valid && imageId == r.CanaryImageId {
+		return false
+	}
+
+	return r.Serves(imageId)
+}
+
+// FallsBack is true when a job bucketed onto the canary may take a VM of imageId
+func (r *Rollout) FallsBack(imageId sql.NullInt64, jobId int64) bool {
+	return r.canary(jobId) && r.Serves(imageId)
+}
+
+func (r *Rollout) canary(jobId int64) bool {
+	return r.CanaryImageId.Valid && jobId%100 < int64(r.CanaryPercent)
+}
+
+func FetchLabelRuntimes() ([]LabelRuntime, error) {
+	runtimes := make([]LabelRuntime, 0)
+	result := db.DB.Order("label ASC").Find(&runtimes)
+
+	return runtimes, result.Error
+}
+
+// SaveLabelRuntime sets the label's maximum runtime, 0 removes it
+func SaveLabelRuntime(runtime *LabelRuntime) *gorm.DB {
+	if runtime.MaxRuntimeMinutes == 0 {
+		return db.DB.Where("label = ?", runtime.Label).Delete(&LabelRuntime{})
+	}
+
+	return db.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "label"}},
+		DoUpdates: clause.AssignmentColumns([]string{"max_runtime_minutes", "updated_at"}),
+	}).Create(runtime)
+}
+
+// LabelRuntimeFor picks the maximum runtime governing a job, that of the first of its labels to have one
+func LabelRuntimeFor(runtimes []LabelRuntime, jobLabels []string) *LabelRuntime {
+	for _, label := range labels.Normalize(jobLabels) {
+		for i := range runtimes {
+			if runtimes[i].Label == label {
+				return &runtimes[i]
+			}
+		}
+	}
+
+	return nil
+}
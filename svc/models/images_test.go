@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRunnerStatus(t *testing.T) {
+	warnWithin := 7 * 24 * time.Hour
+	cases := []struct {
+		name     string
+		deadline sql.NullTime
+		want     RunnerStatus
+	}{
+		{"no deadline", sql.NullTime{}, RunnerCurrent},
+		{"deadline far off", sql.NullTime{Time: time.Now().Add(30 * 24 * time.Hour), Valid: true}, RunnerCurrent},
+		{"deadline within the warning", sql.NullTime{Time: time.Now().Add(3 * 24 * time.Hour), Valid: true}, RunnerExpiring},
+		{"deadline passed", sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}, RunnerDead},
+	}
+
+	for _, c := range cases {
+		image := Image{RunnerDeadline: c.deadline}
+		if got := image.RunnerStatus(warnWithin); got != c.want {
+			t.Errorf("%s: RunnerStatus = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRunnerDead(t *testing.T) {
+	expiring := Image{RunnerDeadline: sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}}
+	if expiring.RunnerDead() {
+		t.Error("a runner before its deadline is not dead")
+	}
+
+	dead := Image{RunnerDeadline: sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}}
+	if !dead.RunnerDead() {
+		t.Error("a runner past its deadline is dead")
+	}
+}
+
+func TestRolloutPlacement(t *testing.T) {
+	stable := sql.NullInt64{Int64: 1, Valid: true}
+	canary := sql.NullInt64{Int64: 2, Valid: true}
+	other := sql.NullInt64{Int64: 3, Valid: true}
+	rollout := Rollout{StableImageId: stable, CanaryImageId: canary, CanaryPercent: 10}
+
+	// job 5 is bucketed onto the canary, job 50 isn't
+	if !rollout.Prefers(canary, 5) || rollout.Prefers(stable, 5) {
+		t.Error("a canary job should prefer the canary")
+	}
+	if !rollout.FallsBack(stable, 5) || rollout.FallsBack(other, 5) {
+		t.Error("a canary job should fall back on the stable image only")
+	}
+
+	if !rollout.Prefers(stable, 50) || rollout.Prefers(canary, 50) || rollout.Prefers(other, 50) {
+		t.Error("a stable job should land on the stable image only")
+	}
+	if rollout.FallsBack(canary, 50) || rollout.FallsBack(stable, 50) {
+		t.Error("a stable job should never fall back, least of all on the canary")
+	}
+}
+
+func TestRolloutWithoutStable(t *testing.T) {
+	canary := sql.NullInt64{Int64: 2, Valid: true}
+	other := sql.NullInt64{Int64: 3, Valid: true}
+	rollout := Rollout{CanaryImageId: canary, CanaryPercent: 10}
+
+	if !rollout.Prefers(other, 50) || rollout.Prefers(canary, 50) {
+		t.Error("without a stable image, a stable job should take anything but the canary")
+	}
+	if !rollout.Prefers(canary, 5) || !rollout.FallsBack(other, 5) {
+		t.Error("without a stable image, a canary job should prefer the canary and fall back on anything")
+	}
+}
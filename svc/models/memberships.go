@@ -0,0 +1,207 @@
+package models
+
+import (
+	"buildkansen/db"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// Role decides what a member may do with an installation
+type Role string
+
+const (
+	// RoleViewer sees the installation's runs, usage and analytics
+	RoleViewer Role = "viewer"
+	// RoleAdmin can also change the installation's settings and cancel its jobs
+	RoleAdmin Role = "admin"
+	// RoleOwner can also manage members and delete the installation's data
+	RoleOwner Role = "owner"
+)
+
+var Roles = []Role{RoleViewer, RoleAdmin, RoleOwner}
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+var (
+	ErrLastOwner = errors.New("an installation needs at least one owner")
+	ErrNotMember = errors.New("not a member of the installation")
+)
+
+func ValidRole(role Role) bool {
+	_, found := roleRanks[role]
+	return found
+}
+
+// Allows is true when the role is at least the required one
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+type Membership struct {
+	Id             int64        `gorm:"primaryKey"`
+	UserId         int64        `gorm:"uniqueIndex:idx_uniq_membership"`
+	User           User         `gorm:"foreignKey:UserId;references:Id"`
+	InstallationId int64        `gorm:"uniqueIndex:idx_uniq_membership"`
+	Installation   Installation `gorm:"foreignKey:InstallationId;references:InternalId"`
+	Role           Role         `gorm:"default:viewer"`
+	CreatedAt      time.Time    `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time    `gorm:"autoUpdateTime"`
+}
+
+func (m Membership) Allows(required Role) bool {
+	return m.Role.Allows(required)
+}
+
+// AddMembership links the user to the installation, an existing membership keeps its role
+func AddMembership(tx *gorm.DB, userId int64, installationId int64, role Role) *gorm.DB {
+	membership := Membership{UserId: userId, InstallationId: installationId, Role: role}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&membership)
+}
+
+func FindMembership(userId int64, installationId int64) (*Membership, error) {
+	membership := Membership{}
+	result := db.DB.
+		Preload("Installation").
+		Where("user_id = ? AND installation_id = ?", userId, installationId).
+		Where("installation_id IN (?)", activeInstallationIds()).
+		First(&membership)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &membership, nil
+}
+
+func FetchMemberships(userId int64) ([]Membership, error) {
+	memberships := make([]Membership, 0)
+	result := db.DB.
+		Preload("Installation").
+		Where("user_id = ? AND installation_id IN (?)", userId, activeInstallationIds()).
+		Order("installation_id").
+		Find(&memberships)
+
+	return memberships, result.Error
+}
+
+// FetchInstallationMembers groups the active members of the installations by installation
+func FetchInstallationMembers(installationIds []int64) (map[int64][]Membership, error) {
+	members := make(map[int64][]Membership)
+	if len(installationIds) == 0 {
+		return members, nil
+	}
+
+	memberships := make([]Membership, 0)
+	result := db.DB.
+		Preload("User").
+		Where("installation_id IN ? AND user_id IN (?)", installationIds, activeUserIds()).
+		Order("installation_id, id").
+		Find(&memberships)
+
+	for _, membership := range memberships {
+		members[membership.InstallationId] = append(members[membership.InstallationId], membership)
+	}
+
+	return members, result.Error
+}
+
+func UpdateMembershipRole(installationId int64, userId int64, role Role) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if role != RoleOwner {
+			if err := ensureOtherOwner(tx, installationId, userId); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Model(&Membership{}).
+			Where("installation_id = ? AND user_id = ?", installationId, userId).
+			Update("role", role)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return ErrNotMember
+		}
+
+		return result.Error
+	})
+}
+
+func RemoveMembership(installationId int64, userId int64) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := ensureOtherOwner(tx, installationId, userId); err != nil {
+			return err
+		}
+
+		result := tx.Where("installation_id = ? AND user_id = ?", installationId, userId).Delete(&Membership{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return ErrNotMember
+		}
+
+		return result.Error
+	})
+}
+
+// RemoveLinkedOrganizationMemberships drops the user from the organization installations other than those kept
+func RemoveLinkedOrganizationMemberships(userId int64, keepInstallationIds []int64) *gorm.DB {
+	query := db.DB.
+		Where("user_id = ? AND role <> ?", userId, RoleOwner).
+		Where("installation_id IN (?)", db.DB.Model(&Installation{}).Select("internal_id").Where("account_type = ?", "Organization"))
+	if len(keepInstallationIds) > 0 {
+		query = query.Where("installation_id NOT IN ?", keepInstallationIds)
+	}
+
+	return query.Delete(&Membership{})
+}
+
+// ensureOtherOwner refuses to demote or remove the user when nobody else owns the installation
+func ensureOtherOwner(tx *gorm.DB, installationId int64, userId int64) error {
+	owners := make([]int64, 0)
+	result := tx.Model(&Membership{}).
+		Where("installation_id = ? AND user_id <> ? AND role = ?", installationId, userId, RoleOwner).
+		Where("user_id IN (?)", activeUserIds()).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Pluck("user_id", &owners)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if len(owners) == 0 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+// DestroyInstallation soft deletes the installation, it is purged after the grace period
+func DestroyInstallation(installation *Installation) *gorm.DB {
+	return db.DB.Delete(installation)
+}
+
+// FetchAccountInstallations finds the installations on the user's own account and on the organizations given by login
+func FetchAccountInstallations(accountId int64, organizationLogins []string) ([]Installation, error) {
+	logins := make([]string, 0, len(organizationLogins))
+	for _, login := range organizationLogins {
+		logins = append(logins, strings.ToLower(login))
+	}
+
+	installations := make([]Installation, 0)
+	query := db.DB.Where("account_type = ? AND account_id = ?", "User", accountId)
+	if len(logins) > 0 {
+		query = query.Or("account_type = ? AND lower(account_login) IN ?", "Organization", logins)
+	}
+	result := query.Order("internal_id").Find(&installations)
+
+	return installations, result.Error
+}
+
+func FetchAllInstallations() ([]Installation, error) {
+	installations := make([]Installation, 0)
+	result := db.DB.Order("internal_id").Find(&installations)
+
+	return installations, result.Error
+}
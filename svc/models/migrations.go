@@ -0,0 +1,283 @@
+package models
+
+import (
+	"buildkansen/db"
+	"buildkansen/log"
+	"database/sql"
+	"embed"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles are the <version>_<name>.up.sql and .down.sql migrations of the schema
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationsLock is the advisory lock held while a migration runs, so processes migrating at once take turns
+const migrationsLock = 4270046
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, if it was
+type MigrationStatus struct {
+	Migration
+	AppliedAt sql.NullTime
+	// Unknown is true for an applied migration this build doesn't have, the database is ahead of it
+	Unknown bool
+}
+
+// Irreversible is true for a migration that has no down
+func (m *Migration) Irreversible() bool {
+	return len(m.Down) == 0
+}
+
+type SchemaMigration struct {
+	Version   int64 `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// Migrations are every migration in this build, oldest first
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		contents, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if len(migration.Up) == 0 {
+			return nil, fmt.Errorf("migration %d_%s needs an up", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// appliedMigrations are the migrations recorded in the database, by version
+func appliedMigrations(tx *gorm.DB) (map[int64]SchemaMigration, error) {
+	applied := make(map[int64]SchemaMigration)
+	if !tx.Migrator().HasTable(&SchemaMigration{}) {
+		return applied, nil
+	}
+
+	rows := make([]SchemaMigration, 0)
+	if result := tx.Order("version").Find(&rows); result.Error != nil {
+		return nil, result.Error
+	}
+
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+// MigrationStatuses are this build's migrations and those the database has that this build doesn't, by version
+func MigrationStatuses() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Migration: migration}
+		if row, found := applied[migration.Version]; found {
+			status.AppliedAt = sql.NullTime{Time: row.AppliedAt, Valid: true}
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, row := range applied {
+		statuses = append(statuses, MigrationStatus{
+			Migration: Migration{Version: row.Version, Name: row.Name},
+			AppliedAt: sql.NullTime{Time: row.AppliedAt, Valid: true},
+			Unknown:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// PendingMigrations are this build's migrations the database hasn't applied yet, oldest first
+func PendingMigrations() ([]Migration, error) {
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]Migration, 0)
+	for _, status := range statuses {
+		if !status.AppliedAt.Valid {
+			pending = append(pending, status.Migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// MigrateUp applies the pending migrations oldest first, each in a transaction of its own
+func MigrateUp() ([]Migration, error) {
+	if err := db.DB.Exec(createSchemaMigrations).Error; err != nil {
+		return nil, err
+	}
+
+	pending, err := PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := make([]Migration, 0, len(pending))
+	for _, migration := range pending {
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			applied, err := lockMigrations(tx)
+			if err != nil {
+				return err
+			}
+			if _, found := applied[migration.Version]; found {
+				return nil
+			}
+
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+
+		migrated = append(migrated, migration)
+	}
+
+	return migrated, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations newest first, each in a transaction of its own
+func MigrateDown(steps int) ([]Migration, error) {
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		return nil, err
+	}
+
+	toRevert := make([]MigrationStatus, 0, steps)
+	for i := len(statuses) - 1; i >= 0 && len(toRevert) < steps; i-- {
+		migration := statuses[i]
+		if !migration.AppliedAt.Valid {
+			continue
+		}
+		if migration.Unknown {
+			return nil, fmt.Errorf("migration %d_%s is not in this build, revert it with the build that applied it", migration.Version, migration.Name)
+		}
+		if migration.Irreversible() {
+			return nil, fmt.Errorf("migration %d_%s can't be reverted, it would drop the data", migration.Version, migration.Name)
+		}
+		toRevert = append(toRevert, migration)
+	}
+
+	reverted := make([]Migration, 0, len(toRevert))
+	for _, migration := range toRevert {
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			current, err := lockMigrations(tx)
+			if err != nil {
+				return err
+			}
+			if _, found := current[migration.Version]; !found {
+				return nil
+			}
+
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+
+			return tx.Delete(&SchemaMigration{Version: migration.Version}).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("reverting migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+
+		reverted = append(reverted, migration.Migration)
+	}
+
+	return reverted, nil
+}
+
+// lockMigrations waits for any other process migrating to finish, and returns what is applied once it has
+func lockMigrations(tx *gorm.DB) (map[int64]SchemaMigration, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationsLock).Error; err != nil {
+		return nil, err
+	}
+
+	return appliedMigrations(tx)
+}
+
+// RequireMigrated refuses to go on when the database hasn't applied every migration this build has
+func RequireMigrated() {
+	pending, err := PendingMigrations()
+	if err != nil {
+		log.Fatalf("Error checking the database schema: %s", err)
+		panic(err)
+	}
+
+	if len(pending) > 0 {
+		log.Fatalf("The database schema is behind by %d migrations, run `buildkansen migrate up` first", len(pending))
+		panic("database schema is behind")
+	}
+}
@@ -0,0 +1,266 @@
+-- The schema as AutoMigrate last left it. Every statement is idempotent so a database AutoMigrate set up is adopted
+-- as it is, and brought up to date if it predates a table, column, index or constraint
+
+CREATE FUNCTION pg_temp.add_constraint(target regclass, constraint_name text, definition text) RETURNS void AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = target AND conname = constraint_name) THEN
+        EXECUTE format('ALTER TABLE %s ADD CONSTRAINT %I %s', target, constraint_name, definition);
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS users (id bigserial PRIMARY KEY);
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS login text,
+    ADD COLUMN IF NOT EXISTS name text,
+    ADD COLUMN IF NOT EXISTS email varchar(100),
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz,
+    ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS installations (internal_id bigserial PRIMARY KEY);
+ALTER TABLE installations
+    ADD COLUMN IF NOT EXISTS id bigint,
+    ADD COLUMN IF NOT EXISTS account_type text,
+    ADD COLUMN IF NOT EXISTS account_id bigint,
+    ADD COLUMN IF NOT EXISTS account_login text,
+    ADD COLUMN IF NOT EXISTS account_avatar_url text,
+    ADD COLUMN IF NOT EXISTS user_id bigint,
+    ADD COLUMN IF NOT EXISTS capacity_policy text DEFAULT 'notify',
+    ADD COLUMN IF NOT EXISTS max_concurrent_jobs bigint,
+    ADD COLUMN IF NOT EXISTS scheduling_weight bigint DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS monthly_minute_budget bigint,
+    ADD COLUMN IF NOT EXISTS soft_limit_percent bigint DEFAULT 80,
+    ADD COLUMN IF NOT EXISTS budget_policy text DEFAULT 'check',
+    ADD COLUMN IF NOT EXISTS budget_warned_for text,
+    ADD COLUMN IF NOT EXISTS budget_exhausted_for text,
+    ADD COLUMN IF NOT EXISTS max_runtime_minutes bigint,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz,
+    ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_installations_deleted_at ON installations (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_installations_user_id ON installations (user_id);
+
+CREATE TABLE IF NOT EXISTS memberships (id bigserial PRIMARY KEY);
+ALTER TABLE memberships
+    ADD COLUMN IF NOT EXISTS user_id bigint,
+    ADD COLUMN IF NOT EXISTS installation_id bigint,
+    ADD COLUMN IF NOT EXISTS role text DEFAULT 'viewer',
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz;
+SELECT pg_temp.add_constraint('memberships', 'fk_installations_memberships', 'FOREIGN KEY (installation_id) REFERENCES installations(internal_id) ON DELETE CASCADE');
+SELECT pg_temp.add_constraint('memberships', 'fk_users_memberships', 'FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE');
+CREATE UNIQUE INDEX IF NOT EXISTS idx_uniq_membership ON memberships (user_id, installation_id);
+
+-- Installations used to be one row per installer, fold the rows teammates created by installing the app again
+-- into the oldest one per GitHub installation. Whoever installed it owns it, the teammates become admins
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_indexes WHERE tablename = 'installations' AND indexname = 'idx_uniq_installation') THEN
+        INSERT INTO memberships (user_id, installation_id, role, created_at, updated_at)
+        SELECT installations.user_id, kept.internal_id,
+               CASE WHEN installations.internal_id = kept.internal_id THEN 'owner' ELSE 'admin' END, now(), now()
+        FROM installations
+        JOIN (SELECT id, min(internal_id) AS internal_id FROM installations GROUP BY id) kept ON kept.id = installations.id
+        JOIN users ON users.id = installations.user_id
+        ORDER BY installations.internal_id
+        ON CONFLICT DO NOTHING;
+
+        DELETE FROM installations WHERE internal_id NOT IN (SELECT min(internal_id) FROM installations GROUP BY id);
+        ALTER TABLE installations DROP CONSTRAINT IF EXISTS fk_users_installations;
+        ALTER TABLE installations DROP CONSTRAINT IF EXISTS fk_installations_user;
+        DROP INDEX idx_uniq_installation;
+    END IF;
+END;
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_installations_id ON installations (id);
+
+CREATE TABLE IF NOT EXISTS repositories (internal_id bigserial PRIMARY KEY);
+ALTER TABLE repositories
+    ADD COLUMN IF NOT EXISTS id bigint,
+    ADD COLUMN IF NOT EXISTS name text,
+    ADD COLUMN IF NOT EXISTS full_name text,
+    ADD COLUMN IF NOT EXISTS private boolean,
+    ADD COLUMN IF NOT EXISTS installation_id bigint,
+    ADD COLUMN IF NOT EXISTS max_concurrent_jobs bigint,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz,
+    ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+SELECT pg_temp.add_constraint('repositories', 'fk_installations_repositories', 'FOREIGN KEY (installation_id) REFERENCES installations(internal_id) ON DELETE CASCADE');
+CREATE INDEX IF NOT EXISTS idx_repositories_deleted_at ON repositories (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_uniq_repository ON repositories (id, installation_id);
+
+CREATE TABLE IF NOT EXISTS images (id bigserial PRIMARY KEY);
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS name text,
+    ADD COLUMN IF NOT EXISTS version text,
+    ADD COLUMN IF NOT EXISTS mac_os_version text,
+    ADD COLUMN IF NOT EXISTS xcode_version text,
+    ADD COLUMN IF NOT EXISTS runner_version text,
+    ADD COLUMN IF NOT EXISTS checksum text,
+    ADD COLUMN IF NOT EXISTS built_at timestamptz,
+    ADD COLUMN IF NOT EXISTS runner_latest_version text,
+    ADD COLUMN IF NOT EXISTS runner_deadline timestamptz,
+    ADD COLUMN IF NOT EXISTS runner_checked_at timestamptz,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_uniq_image ON images (name, version);
+
+CREATE TABLE IF NOT EXISTS vms (id bigserial PRIMARY KEY);
+ALTER TABLE vms
+    ADD COLUMN IF NOT EXISTS vm_ip_address text,
+    ADD COLUMN IF NOT EXISTS vm_instance_name text,
+    ADD COLUMN IF NOT EXISTS base_vm_name text,
+    ADD COLUMN IF NOT EXISTS github_runner_label text,
+    ADD COLUMN IF NOT EXISTS host text,
+    ADD COLUMN IF NOT EXISTS image_id bigint,
+    ADD COLUMN IF NOT EXISTS external_run_id bigint,
+    ADD COLUMN IF NOT EXISTS repository_id bigint,
+    ADD COLUMN IF NOT EXISTS status text,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz;
+SELECT pg_temp.add_constraint('vms', 'fk_vms_image', 'FOREIGN KEY (image_id) REFERENCES images(id)');
+SELECT pg_temp.add_constraint('vms', 'fk_vms_repository', 'FOREIGN KEY (repository_id) REFERENCES repositories(internal_id)');
+
+CREATE TABLE IF NOT EXISTS workflow_job_runs (internal_id bigserial PRIMARY KEY);
+ALTER TABLE workflow_job_runs
+    ADD COLUMN IF NOT EXISTS id bigint,
+    ADD COLUMN IF NOT EXISTS name text,
+    ADD COLUMN IF NOT EXISTS url text,
+    ADD COLUMN IF NOT EXISTS workflow_run_id bigint,
+    ADD COLUMN IF NOT EXISTS run_attempt bigint DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS workflow_name text,
+    ADD COLUMN IF NOT EXISTS status text,
+    ADD COLUMN IF NOT EXISTS conclusion text,
+    ADD COLUMN IF NOT EXISTS head_sha text,
+    ADD COLUMN IF NOT EXISTS head_branch text,
+    ADD COLUMN IF NOT EXISTS labels text,
+    ADD COLUMN IF NOT EXISTS priority bigint,
+    ADD COLUMN IF NOT EXISTS vm_instance_name text,
+    ADD COLUMN IF NOT EXISTS vm_host text,
+    ADD COLUMN IF NOT EXISTS check_run_id bigint,
+    ADD COLUMN IF NOT EXISTS failure_reason text,
+    ADD COLUMN IF NOT EXISTS repository_id bigint,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz,
+    ADD COLUMN IF NOT EXISTS started_at timestamptz,
+    ADD COLUMN IF NOT EXISTS kickoff_at timestamptz,
+    ADD COLUMN IF NOT EXISTS processing_at timestamptz,
+    ADD COLUMN IF NOT EXISTS ended_at timestamptz,
+    ADD COLUMN IF NOT EXISTS escalated_at timestamptz,
+    ADD COLUMN IF NOT EXISTS assigned_at timestamptz,
+    ADD COLUMN IF NOT EXISTS queue_seconds bigint,
+    ADD COLUMN IF NOT EXISTS run_seconds bigint;
+SELECT pg_temp.add_constraint('workflow_job_runs', 'fk_repositories_workflow_job_runs', 'FOREIGN KEY (repository_id) REFERENCES repositories(internal_id) ON DELETE CASCADE');
+
+CREATE TABLE IF NOT EXISTS notifications (id bigserial PRIMARY KEY);
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS installation_id bigint,
+    ADD COLUMN IF NOT EXISTS kind text,
+    ADD COLUMN IF NOT EXISTS message text,
+    ADD COLUMN IF NOT EXISTS read_at timestamptz,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz;
+SELECT pg_temp.add_constraint('notifications', 'fk_installations_notifications', 'FOREIGN KEY (installation_id) REFERENCES installations(internal_id) ON DELETE CASCADE');
+
+CREATE TABLE IF NOT EXISTS rollouts (id bigserial PRIMARY KEY);
+ALTER TABLE rollouts
+    ADD COLUMN IF NOT EXISTS label text,
+    ADD COLUMN IF NOT EXISTS stable_image_id bigint,
+    ADD COLUMN IF NOT EXISTS canary_image_id bigint,
+    ADD COLUMN IF NOT EXISTS canary_percent bigint,
+    ADD COLUMN IF NOT EXISTS max_runtime_minutes bigint,
+    ADD COLUMN IF NOT EXISTS previous_image_id bigint,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz;
+SELECT pg_temp.add_constraint('rollouts', 'fk_rollouts_stable_image', 'FOREIGN KEY (stable_image_id) REFERENCES images(id)');
+SELECT pg_temp.add_constraint('rollouts', 'fk_rollouts_canary_image', 'FOREIGN KEY (canary_image_id) REFERENCES images(id)');
+SELECT pg_temp.add_constraint('rollouts', 'fk_rollouts_previous_image', 'FOREIGN KEY (previous_image_id) REFERENCES images(id)');
+CREATE UNIQUE INDEX IF NOT EXISTS idx_rollouts_label ON rollouts (label);
+
+CREATE TABLE IF NOT EXISTS usage_records (id bigserial PRIMARY KEY);
+ALTER TABLE usage_records
+    ADD COLUMN IF NOT EXISTS workflow_job_run_id bigint,
+    ADD COLUMN IF NOT EXISTS installation_id bigint,
+    ADD COLUMN IF NOT EXISTS repository_id bigint,
+    ADD COLUMN IF NOT EXISTS label text,
+    ADD COLUMN IF NOT EXISTS host text,
+    ADD COLUMN IF NOT EXISTS vm_seconds bigint,
+    ADD COLUMN IF NOT EXISTS billable_minutes bigint,
+    ADD COLUMN IF NOT EXISTS started_at timestamptz,
+    ADD COLUMN IF NOT EXISTS ended_at timestamptz,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz;
+SELECT pg_temp.add_constraint('usage_records', 'fk_usage_records_workflow_job_run', 'FOREIGN KEY (workflow_job_run_id) REFERENCES workflow_job_runs(internal_id) ON DELETE CASCADE');
+SELECT pg_temp.add_constraint('usage_records', 'fk_usage_records_installation', 'FOREIGN KEY (installation_id) REFERENCES installations(internal_id) ON DELETE CASCADE');
+CREATE INDEX IF NOT EXISTS idx_usage_records_installation_id ON usage_records (installation_id);
+CREATE INDEX IF NOT EXISTS idx_usage_records_started_at ON usage_records (started_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_usage_records_workflow_job_run_id ON usage_records (workflow_job_run_id);
+
+CREATE TABLE IF NOT EXISTS api_tokens (id bigserial PRIMARY KEY);
+ALTER TABLE api_tokens
+    ADD COLUMN IF NOT EXISTS user_id bigint,
+    ADD COLUMN IF NOT EXISTS name text,
+    ADD COLUMN IF NOT EXISTS prefix text,
+    ADD COLUMN IF NOT EXISTS token_hash text,
+    ADD COLUMN IF NOT EXISTS scopes text,
+    ADD COLUMN IF NOT EXISTS expires_at timestamptz,
+    ADD COLUMN IF NOT EXISTS last_used_at timestamptz,
+    ADD COLUMN IF NOT EXISTS revoked_at timestamptz,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz;
+SELECT pg_temp.add_constraint('api_tokens', 'fk_api_tokens_user', 'FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE');
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS hosts (id bigserial PRIMARY KEY);
+ALTER TABLE hosts
+    ADD COLUMN IF NOT EXISTS name text,
+    ADD COLUMN IF NOT EXISTS drained boolean,
+    ADD COLUMN IF NOT EXISTS drained_at timestamptz,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_hosts_name ON hosts (name);
+
+CREATE TABLE IF NOT EXISTS audit_events (id bigserial PRIMARY KEY);
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS actor_kind text,
+    ADD COLUMN IF NOT EXISTS actor_id bigint,
+    ADD COLUMN IF NOT EXISTS actor_login text,
+    ADD COLUMN IF NOT EXISTS api_token_id bigint,
+    ADD COLUMN IF NOT EXISTS action text,
+    ADD COLUMN IF NOT EXISTS target_type text,
+    ADD COLUMN IF NOT EXISTS target_id text,
+    ADD COLUMN IF NOT EXISTS installation_id bigint,
+    ADD COLUMN IF NOT EXISTS "before" jsonb,
+    ADD COLUMN IF NOT EXISTS "after" jsonb,
+    ADD COLUMN IF NOT EXISTS ip_address text,
+    ADD COLUMN IF NOT EXISTS user_agent text,
+    ADD COLUMN IF NOT EXISTS method text,
+    ADD COLUMN IF NOT EXISTS path text,
+    ADD COLUMN IF NOT EXISTS created_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_kind ON audit_events (actor_kind);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_installation_id ON audit_events (installation_id);
+
+-- Audit events are append-only, the database refuses to change or remove them
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- Rows were saved with a zero deletion time before deletion was soft, NULL is what marks a row as not deleted
+UPDATE users SET deleted_at = NULL WHERE deleted_at < '1970-01-01';
+UPDATE installations SET deleted_at = NULL WHERE deleted_at < '1970-01-01';
+UPDATE repositories SET deleted_at = NULL WHERE deleted_at < '1970-01-01';
+
+DROP FUNCTION pg_temp.add_constraint(regclass, text, text);
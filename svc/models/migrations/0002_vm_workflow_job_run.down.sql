@@ -0,0 +1,7 @@
+ALTER TABLE vms ADD COLUMN external_run_id bigint;
+
+UPDATE vms SET external_run_id = workflow_job_runs.workflow_run_id
+FROM workflow_job_runs
+WHERE workflow_job_runs.internal_id = vms.workflow_job_run_id;
+
+ALTER TABLE vms DROP COLUMN workflow_job_run_id;
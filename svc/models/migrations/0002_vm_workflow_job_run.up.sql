@@ -0,0 +1,15 @@
+-- VMs were tied to the workflow run of the job they picked up, which every job of a run shares, so a job finishing
+-- could tear down the VM of another job in its run. A VM now points at the job run it is running
+ALTER TABLE vms ADD COLUMN workflow_job_run_id bigint;
+ALTER TABLE vms ADD CONSTRAINT fk_vms_workflow_job_run FOREIGN KEY (workflow_job_run_id)
+    REFERENCES workflow_job_runs (internal_id) ON DELETE SET NULL;
+CREATE INDEX idx_vms_workflow_job_run_id ON vms (workflow_job_run_id);
+
+-- The job run a busy VM is running is the one of its workflow run that was assigned the VM's instance
+UPDATE vms SET workflow_job_run_id = workflow_job_runs.internal_id
+FROM workflow_job_runs
+WHERE workflow_job_runs.workflow_run_id = vms.external_run_id
+  AND workflow_job_runs.repository_id = vms.repository_id
+  AND workflow_job_runs.vm_instance_name = vms.vm_instance_name;
+
+ALTER TABLE vms DROP COLUMN external_run_id;
@@ -0,0 +1,8 @@
+ALTER TABLE usage_records DROP CONSTRAINT fk_usage_records_workflow_job_run;
+ALTER TABLE usage_records ADD CONSTRAINT fk_usage_records_workflow_job_run FOREIGN KEY (workflow_job_run_id)
+    REFERENCES workflow_job_runs (internal_id) ON DELETE CASCADE;
+
+ALTER TABLE usage_records DROP COLUMN job_name;
+ALTER TABLE usage_records DROP COLUMN workflow_name;
+ALTER TABLE usage_records DROP COLUMN repository;
+ALTER TABLE usage_records DROP COLUMN job_id;
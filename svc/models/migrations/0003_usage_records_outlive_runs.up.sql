@@ -0,0 +1,18 @@
+-- Usage records are the billing history, they must outlive the runs the retention purge removes. They keep what
+-- the usage export shows of their run, and lose only the link to it
+ALTER TABLE usage_records ADD COLUMN job_id bigint NOT NULL DEFAULT 0;
+ALTER TABLE usage_records ADD COLUMN repository text NOT NULL DEFAULT '';
+ALTER TABLE usage_records ADD COLUMN workflow_name text NOT NULL DEFAULT '';
+ALTER TABLE usage_records ADD COLUMN job_name text NOT NULL DEFAULT '';
+
+UPDATE usage_records SET job_id = workflow_job_runs.id,
+                         repository = repositories.full_name,
+                         workflow_name = workflow_job_runs.workflow_name,
+                         job_name = workflow_job_runs.name
+FROM workflow_job_runs
+JOIN repositories ON repositories.internal_id = workflow_job_runs.repository_id
+WHERE workflow_job_runs.internal_id = usage_records.workflow_job_run_id;
+
+ALTER TABLE usage_records DROP CONSTRAINT fk_usage_records_workflow_job_run;
+ALTER TABLE usage_records ADD CONSTRAINT fk_usage_records_workflow_job_run FOREIGN KEY (workflow_job_run_id)
+    REFERENCES workflow_job_runs (internal_id) ON DELETE SET NULL;
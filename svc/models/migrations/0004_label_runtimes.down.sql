@@ -0,0 +1,7 @@
+ALTER TABLE rollouts ADD COLUMN max_runtime_minutes bigint;
+
+INSERT INTO rollouts (label, canary_percent, max_runtime_minutes, created_at, updated_at)
+SELECT label, 0, max_runtime_minutes, created_at, updated_at FROM label_runtimes
+ON CONFLICT (label) DO UPDATE SET max_runtime_minutes = EXCLUDED.max_runtime_minutes;
+
+DROP TABLE label_runtimes;
@@ -0,0 +1,18 @@
+-- A label's maximum runtime was kept on its rollout, which left a rollout without images behind for every label
+-- given one. Maximum runtimes have a table of their own
+CREATE TABLE label_runtimes (
+    id bigserial PRIMARY KEY,
+    label text NOT NULL,
+    max_runtime_minutes bigint NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE UNIQUE INDEX idx_label_runtimes_label ON label_runtimes (label);
+
+INSERT INTO label_runtimes (label, max_runtime_minutes, created_at, updated_at)
+SELECT label, max_runtime_minutes, created_at, updated_at FROM rollouts WHERE max_runtime_minutes > 0;
+
+-- the rollouts that only held a maximum runtime
+DELETE FROM rollouts WHERE stable_image_id IS NULL AND canary_image_id IS NULL AND previous_image_id IS NULL;
+
+ALTER TABLE rollouts DROP COLUMN max_runtime_minutes;
@@ -0,0 +1,12 @@
+-- The label sets jobs can runs-on were those of the VMs in the pool, they are now those of the registered images.
+-- An image takes the labels of the first VM parked from it
+ALTER TABLE images ADD COLUMN labels text NOT NULL DEFAULT '';
+
+UPDATE images SET labels = first_vms.github_runner_label
+FROM (
+    SELECT DISTINCT ON (image_id) image_id, github_runner_label
+    FROM vms
+    WHERE image_id IS NOT NULL AND github_runner_label IS NOT NULL
+    ORDER BY image_id, id
+) AS first_vms
+WHERE first_vms.image_id = images.id;
@@ -2,9 +2,10 @@ package models
 
 import (
 	"buildkansen/db"
-	"buildkansen/log"
+	"buildkansen/internal/labels"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -12,70 +13,130 @@ import (
 )
 
 type User struct {
-	Id            int64 `gorm:"primaryKey"`
-	Name          string
-	Email         string    `gorm:"type:varchar(100);unique_index"`
-	CreatedAt     time.Time `gorm:"autoCreateTime"`
-	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
-	DeletedAt     time.Time
-	Installations []Installation `gorm:"foreignKey:UserId;constraint:OnDelete:CASCADE"`
+	Id          int64 `gorm:"primaryKey"`
+	Login       string
+	Name        string
+	Email       string         `gorm:"type:varchar(100);unique_index"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Memberships []Membership   `gorm:"foreignKey:UserId;constraint:OnDelete:CASCADE"`
 }
 
 type Installation struct {
 	InternalId       int64 `gorm:"primaryKey"`
-	Id               int64 `gorm:"index:idx_uniq_installation,unique"`
+	Id               int64 `gorm:"uniqueIndex"`
 	AccountType      string
 	AccountID        int64
 	AccountLogin     string
 	AccountAvatarUrl string
-	UserId           int64        `gorm:"index:idx_uniq_installation,unique"`
-	User             User         `gorm:"foreignKey:UserId;references:Id"`
-	Repositories     []Repository `gorm:"foreignKey:InstallationId;constraint:OnDelete:CASCADE"`
-	CreatedAt        time.Time    `gorm:"autoCreateTime"`
-	UpdatedAt        time.Time    `gorm:"autoUpdateTime"`
-	DeletedAt        time.Time
+	// UserId is who installed the app, memberships decide who else can see and manage the installation
+	UserId         int64          `gorm:"index"`
+	CapacityPolicy CapacityPolicy `gorm:"default:notify"`
+	// MaxConcurrentJobs caps the VMs the installation can hold at once, 0 is no cap
+	MaxConcurrentJobs int
+	// SchedulingWeight is the installation's share of the pool relative to others when VMs are contended
+	SchedulingWeight int `gorm:"default:1"`
+	// MonthlyMinuteBudget is the billable minutes the installation may use each calendar month, 0 is unlimited
+	MonthlyMinuteBudget int64
+	SoftLimitPercent    int          `gorm:"default:80"`
+	BudgetPolicy        BudgetPolicy `gorm:"default:check"`
+	// BudgetWarnedFor and BudgetExhaustedFor hold the month (YYYY-MM) the owner was last told about each limit
+	BudgetWarnedFor    string
+	BudgetExhaustedFor string
+	// MaxRuntimeMinutes overrides the maximum runtime of the labels for the installation's jobs, 0 keeps the labels'
+	MaxRuntimeMinutes int
+	Repositories      []Repository   `gorm:"foreignKey:InstallationId;constraint:OnDelete:CASCADE"`
+	Notifications     []Notification `gorm:"foreignKey:InstallationId;constraint:OnDelete:CASCADE"`
+	Memberships       []Membership   `gorm:"foreignKey:InstallationId;constraint:OnDelete:CASCADE"`
+	CreatedAt         time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt         gorm.DeletedAt `gorm:"index"`
 }
 
+// CapacityPolicy decides what happens to a job that no VM can pick up in time
+type CapacityPolicy string
+
+const (
+	CapacityPolicyNotify CapacityPolicy = "notify"
+	CapacityPolicyCancel CapacityPolicy = "cancel"
+)
+
+// BudgetPolicy decides what happens to jobs queued once an installation's minute budget is spent
+type BudgetPolicy string
+
+const (
+	BudgetPolicyCheck  BudgetPolicy = "check"
+	BudgetPolicyCancel BudgetPolicy = "cancel"
+)
+
 type Repository struct {
-	InternalId      int64 `gorm:"primaryKey"`
-	Id              int64 `gorm:"index:idx_uniq_repository,unique"`
-	Name            string
-	FullName        string
-	Private         bool
-	InstallationId  int64            `gorm:"index:idx_uniq_repository,unique"`
-	Installation    Installation     `gorm:"foreignKey:InstallationId;references:InternalId"`
-	WorkflowJobRuns []WorkflowJobRun `gorm:"foreignKey:RepositoryId;constraint:OnDelete:CASCADE"`
-	CreatedAt       time.Time        `gorm:"autoCreateTime"`
-	UpdatedAt       time.Time        `gorm:"autoUpdateTime"`
-	DeletedAt       time.Time
+	InternalId     int64 `gorm:"primaryKey"`
+	Id             int64 `gorm:"index:idx_uniq_repository,unique"`
+	Name           string
+	FullName       string
+	Private        bool
+	InstallationId int64 `gorm:"index:idx_uniq_repository,unique"`
+	// MaxConcurrentJobs caps the VMs the repository can hold at once, 0 is no cap
+	MaxConcurrentJobs int
+	Installation      Installation     `gorm:"foreignKey:InstallationId;references:InternalId"`
+	WorkflowJobRuns   []WorkflowJobRun `gorm:"foreignKey:RepositoryId;constraint:OnDelete:CASCADE"`
+	CreatedAt         time.Time        `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time        `gorm:"autoUpdateTime"`
+	DeletedAt         gorm.DeletedAt   `gorm:"index"`
 }
 
+func (r Repository) HtmlUrl() string {
+	return "https://github.com/" + r.FullName
+}
+
+// RecentRunsLimit is how many runs the dashboard shows, the rest are on the runs page
+const RecentRunsLimit = 20
+
 type WorkflowJobRun struct {
-	InternalId    int64 `gorm:"primaryKey"`
-	Id            int64
-	Name          string
-	Url           string
-	WorkflowRunId int64
-	WorkflowName  string
-	Status        string
-	Conclusion    sql.NullString
-	RepositoryId  int64
-	Repository    Repository `gorm:"foreignKey:RepositoryId;references:InternalId"`
-	CreatedAt     time.Time  `gorm:"autoCreateTime"`
-	UpdatedAt     time.Time  `gorm:"autoUpdateTime"`
-	StartedAt     time.Time
-	KickoffAt     sql.NullTime
-	ProcessingAt  sql.NullTime
-	EndedAt       sql.NullTime
-	RunDuration   time.Duration `gorm:"-"`
-	QueueDuration time.Duration `gorm:"-"`
+	InternalId     int64 `gorm:"primaryKey"`
+	Id             int64
+	Name           string
+	Url            string
+	WorkflowRunId  int64
+	RunAttempt     int `gorm:"default:1"`
+	WorkflowName   string
+	Status         string
+	Conclusion     sql.NullString
+	HeadSha        string
+	HeadBranch     string
+	Labels         string
+	Priority       int
+	VMInstanceName string
+	VMHost         string
+	CheckRunId     sql.NullInt64
+	FailureReason  sql.NullString
+	RepositoryId   int64
+	Repository     Repository `gorm:"foreignKey:RepositoryId;references:InternalId"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime"`
+	StartedAt      time.Time
+	KickoffAt      sql.NullTime
+	ProcessingAt   sql.NullTime
+	EndedAt        sql.NullTime
+	EscalatedAt    sql.NullTime
+	AssignedAt     sql.NullTime
+	QueueSeconds   sql.NullInt64
+	RunSeconds     sql.NullInt64
+	RunDuration    time.Duration `gorm:"-"`
+	QueueDuration  time.Duration `gorm:"-"`
 }
 
+// TimedOutConclusion is the conclusion of a run we stopped for exceeding its maximum runtime
+const TimedOutConclusion = "timed_out"
+
 type VMStatus string
 
 const (
 	VMAvailable  VMStatus = "available"
 	VMProcessing VMStatus = "processing"
+	// VMPurging VMs are waiting for the scheduler of their host to tear their guest down
+	VMPurging VMStatus = "purging"
 )
 
 type VM struct {
@@ -84,23 +145,30 @@ type VM struct {
 	VMInstanceName    string
 	BaseVMName        string
 	GithubRunnerLabel string
-	ExternalRunId     sql.NullInt64
-	RepositoryId      sql.NullInt64
-	Repository        Repository `gorm:"foreignKey:RepositoryId;references:InternalId"`
-	Status            VMStatus   `sql:"type:enum('available', 'processing')"`
-	CreatedAt         time.Time  `gorm:"autoCreateTime"`
-	UpdatedAt         time.Time  `gorm:"autoUpdateTime"`
+	Host              string
+	ImageId           sql.NullInt64
+	Image             *Image `gorm:"foreignKey:ImageId;references:Id"`
+	// WorkflowJobRunId is the internal id of the job run the VM is running
+	WorkflowJobRunId sql.NullInt64
+	RepositoryId     sql.NullInt64
+	Repository       Repository `gorm:"foreignKey:RepositoryId;references:InternalId"`
+	Status           VMStatus   `sql:"type:enum('available', 'processing', 'purging')"`
+	CreatedAt        time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt        time.Time  `gorm:"autoUpdateTime"`
 }
 
-func Migrate() {
-	if err := db.DB.AutoMigrate(&User{}, &Installation{}, &Repository{}, &VM{}, &WorkflowJobRun{}); err != nil {
-		log.Fatalf("Error migrating the database")
-		panic(err)
-	}
+type Notification struct {
+	Id             int64 `gorm:"primaryKey"`
+	InstallationId int64
+	Installation   Installation `gorm:"foreignKey:InstallationId;references:InternalId"`
+	Kind           string
+	Message        string
+	ReadAt         sql.NullTime
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
 }
 
 type models interface {
-	Installation | Repository | User | VM
+	Installation | Repository | User | VM | Image
 }
 
 type values interface {
@@ -122,6 +190,58 @@ func FindEntity[U models, V values](m U, value V, by string) (interface{}, error
 	return m, nil
 }
 
+// Pending is true while the run waits in the queue for a VM
+func (r *WorkflowJobRun) Pending() bool {
+	return r.Status == "queued" && !r.AssignedAt.Valid && !r.KickoffAt.Valid && !r.EndedAt.Valid && !r.FailureReason.Valid
+}
+
+// DisplayStatus is the run's state as the dashboard shows it, our own failures take precedence over GitHub's outcome
+func (r *WorkflowJobRun) DisplayStatus() string {
+	switch {
+	case r.Conclusion.String == TimedOutConclusion:
+		return "timed out"
+	case r.FailureReason.Valid:
+		return "infrastructure failure"
+	case r.Conclusion.Valid:
+		return r.Conclusion.String
+	default:
+		return r.Status
+	}
+}
+
+// Durations are how long the run waited for a runner and how long it ran
+func (r *WorkflowJobRun) Durations() (time.Duration, time.Duration) {
+	if r.QueueSeconds.Valid && r.RunSeconds.Valid {
+		return time.Duration(r.QueueSeconds.Int64) * time.Second, time.Duration(r.RunSeconds.Int64) * time.Second
+	}
+
+	if !r.ProcessingAt.Valid {
+		if r.EndedAt.Valid {
+			return r.EndedAt.Time.Sub(r.StartedAt), 0 // job ended without ever running
+		}
+
+		return time.Since(r.StartedAt), 0 // job is still queued
+	}
+
+	queueTime := r.ProcessingAt.Time.Sub(r.StartedAt)
+	if r.EndedAt.Valid {
+		return queueTime, r.EndedAt.Time.Sub(r.ProcessingAt.Time) // job has ended
+	}
+
+	return queueTime, time.Since(r.ProcessingAt.Time) // job is still running
+}
+
+// FindInstallationRepository finds a repository by its internal id, only within the installation of the internal id
+func FindInstallationRepository(installationId int64, internalId int64) (*Repository, error) {
+	repository := Repository{}
+	result := db.DB.Where("internal_id = ? AND installation_id = ?", internalId, installationId).First(&repository)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &repository, nil
+}
+
 func FindRepositoryByInstallation(installationId int64, repositoryId int64) (*Repository, error) {
 	repository := Repository{}
 	result := db.DB.Model(&repository).Where("id = ? AND installation_id = ?", repositoryId, installationId).First(&repository)
@@ -133,58 +253,79 @@ func FindRepositoryByInstallation(installationId int64, repositoryId int64) (*Re
 	return &repository, nil
 }
 
-func FetchUserData(user *User) ([]Installation, []Repository, []WorkflowJobRun) {
-	db.DB.Preload("Installations.Repositories.WorkflowJobRuns", func(db *gorm.DB) *gorm.DB {
-		return db.Order("workflow_job_runs.created_at DESC").Limit(20)
-	}).Preload("Installations.Repositories.WorkflowJobRuns.Repository").Preload(clause.Associations).First(&user, user.Id)
+func FindRepositoryWithInstallation(internalId int64) (*Repository, error) {
+	repository := Repository{}
+	result := db.DB.Preload("Installation").Where("internal_id = ?", internalId).First(&repository)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &repository, nil
+}
 
+// FetchRepositoriesWithRuns returns every repository that has ever had a job routed to our runners
+func FetchRepositoriesWithRuns() ([]Repository, error) {
 	repositories := make([]Repository, 0)
-	runs := make([]WorkflowJobRun, 0)
-
-	for _, installation := range user.Installations {
-		for _, repository := range installation.Repositories {
-			repositories = append(repositories, repository)
-			for _, workflowJobRun := range repository.WorkflowJobRuns {
-				executionTime, queueTime := time.Duration(0), time.Duration(0)
-
-				if workflowJobRun.ProcessingAt.Valid {
-					queueTime = workflowJobRun.ProcessingAt.Time.Sub(workflowJobRun.StartedAt)
-					processingAt := workflowJobRun.ProcessingAt.Time
-					startedAt := workflowJobRun.StartedAt
-
-					if workflowJobRun.EndedAt.Valid {
-						executionTime = workflowJobRun.EndedAt.Time.Sub(startedAt) // job has ended
-					} else {
-						executionTime = time.Now().Sub(processingAt) // job is still running
-					}
-				} else {
-					queueTime = time.Now().Sub(workflowJobRun.StartedAt)
-				}
-
-				workflowJobRun.QueueDuration = queueTime
-				workflowJobRun.RunDuration = executionTime
-				runs = append(runs, workflowJobRun)
-			}
-		}
+	result := db.DB.
+		Preload("Installation").
+		Where("internal_id IN (?)", db.DB.Model(&WorkflowJobRun{}).Distinct("repository_id")).
+		Find(&repositories)
+
+	return repositories, result.Error
+}
+
+func FetchUserData(user *User) ([]Installation, []Repository, []WorkflowJobRun) {
+	installations := make([]Installation, 0)
+	db.DB.
+		Preload("Repositories").
+		Where("internal_id IN (?)", db.DB.Model(&Membership{}).Select("installation_id").Where("user_id = ?", user.Id)).
+		Order("internal_id").
+		Find(&installations)
+
+	installationIds := make([]int64, 0)
+	repositories := make([]Repository, 0)
+
+	for _, installation := range installations {
+		installationIds = append(installationIds, installation.InternalId)
+		repositories = append(repositories, installation.Repositories...)
 	}
 
-	return user.Installations, repositories, runs
+	runs, _, err := FetchRuns(installationIds, RunFilter{Page: 1, PerPage: RecentRunsLimit})
+	if err != nil {
+		fmt.Println("could not fetch recent runs: ", err)
+	}
+
+	return installations, repositories, runs
 }
 
+// DestroyUserData soft deletes the user and the installations nobody else owns
 func DestroyUserData(user *User) error {
-	result := db.DB.Delete(&user) // we cascade delete all related data
-	if result.Error != nil {
+	deletedAt := time.Now()
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Installation{}).
+			Where("internal_id IN (?)", tx.Model(&Membership{}).Select("installation_id").Where("user_id = ? AND role = ?", user.Id, RoleOwner)).
+			Where("NOT EXISTS (?)", tx.Model(&Membership{}).Select("1").Where("memberships.installation_id = installations.internal_id AND memberships.user_id <> ? AND memberships.role = ? AND memberships.user_id IN (?)", user.Id, RoleOwner, activeUserIds())).
+			Update("deleted_at", deletedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Model(user).Update("deleted_at", deletedAt).Error
+	})
+	if err != nil {
 		return errors.New("failed to destroy user data")
 	}
 
 	return nil
 }
 
-func UpsertUser(id int64, name string, email string) (*gorm.DB, User) {
-	u := User{Id: id, Name: name, Email: email}
+func UpsertUser(id int64, login string, name string, email string) (*gorm.DB, User) {
+	u := User{Id: id, Login: login, Name: name, Email: email}
 	result := db.DB.Clauses(clause.OnConflict{
 		Columns: []clause.Column{{Name: "id"}},
 		DoUpdates: clause.AssignmentColumns([]string{
+			"login",
 			"email",
 			"name",
 		}),
@@ -197,8 +338,13 @@ func CreateWorkflowJobRun(id int64,
 	name string,
 	url string,
 	runId int64,
+	runAttempt int,
 	workflowName string,
 	status string,
+	headSha string,
+	headBranch string,
+	runnerLabels string,
+	priority int,
 	repositoryId int64,
 	startedAt time.Time) *gorm.DB {
 
@@ -207,8 +353,13 @@ func CreateWorkflowJobRun(id int64,
 		Name:          name,
 		Url:           url,
 		WorkflowRunId: runId,
+		RunAttempt:    runAttempt,
 		WorkflowName:  workflowName,
 		Status:        status,
+		HeadSha:       headSha,
+		HeadBranch:    headBranch,
+		Labels:        runnerLabels,
+		Priority:      priority,
 		RepositoryId:  repositoryId,
 		StartedAt:     startedAt,
 	}
@@ -216,6 +367,121 @@ func CreateWorkflowJobRun(id int64,
 	return db.DB.Create(&jobRun)
 }
 
+// PendingWorkflowJobRuns is the queue: runs that are waiting for a VM, by priority and then oldest first
+func PendingWorkflowJobRuns() ([]WorkflowJobRun, error) {
+	jobRuns := make([]WorkflowJobRun, 0)
+	result := db.DB.
+		Preload("Repository.Installation").
+		Where("status = ? AND assigned_at IS NULL AND kickoff_at IS NULL AND ended_at IS NULL AND failure_reason IS NULL", "queued").
+		Where("repository_id IN (?)", activeRepositoryIds()).
+		Order("priority DESC, started_at ASC").
+		Find(&jobRuns)
+
+	return jobRuns, result.Error
+}
+
+func FindWorkflowJobRun(id int64, repositoryId int64) (*WorkflowJobRun, error) {
+	jobRun := WorkflowJobRun{}
+	result := db.DB.
+		Preload("Repository.Installation").
+		Where("id = ? AND repository_id = ?", id, repositoryId).
+		First(&jobRun)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &jobRun, nil
+}
+
+// WorkflowJobRunQueuePosition is the 1-indexed position of the run among the runs waiting for the same label
+func WorkflowJobRunQueuePosition(jobRun *WorkflowJobRun) (int64, error) {
+	var ahead int64
+	result := db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("status = ? AND kickoff_at IS NULL AND ended_at IS NULL AND failure_reason IS NULL", "queued").
+		Where("labels = ?", jobRun.Labels).
+		Where("started_at < ?", jobRun.StartedAt).
+		Count(&ahead)
+
+	return ahead + 1, result.Error
+}
+
+// ClaimWorkflowJobRun takes a pending run for the scheduler of the host, RowsAffected is 0 if another got it
+func ClaimWorkflowJobRun(id int64, repositoryId int64, host string) *gorm.DB {
+	updates := &WorkflowJobRun{VMHost: host, AssignedAt: sql.NullTime{Time: time.Now(), Valid: true}}
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ? AND status = ? AND assigned_at IS NULL AND kickoff_at IS NULL AND ended_at IS NULL AND failure_reason IS NULL", id, repositoryId, "queued").
+		Updates(updates)
+}
+
+// ReleaseWorkflowJobRunClaims puts the runs the host's scheduler claimed but never kicked off back in the queue
+func ReleaseWorkflowJobRunClaims(host string) (int64, error) {
+	runUpdates := map[string]interface{}{
+		"vm_instance_name": "",
+		"vm_host":          "",
+		"assigned_at":      gorm.Expr("NULL"),
+	}
+	vmUpdates := map[string]interface{}{
+		"workflow_job_run_id": gorm.Expr("NULL"),
+		"repository_id":       gorm.Expr("NULL"),
+		"vm_instance_name":    gorm.Expr("NULL"),
+		"status":              VMAvailable,
+	}
+	claimed := "vm_host = ? AND assigned_at IS NOT NULL AND kickoff_at IS NULL AND ended_at IS NULL AND failure_reason IS NULL"
+
+	var released int64
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Model(&VM{}).
+			Where("host = ? AND status = ? AND workflow_job_run_id IN (?)",
+				host, VMProcessing, tx.Model(&WorkflowJobRun{}).Select("internal_id").Where(claimed, host)).
+			Updates(vmUpdates)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Model(&WorkflowJobRun{}).Where(claimed, host).Updates(runUpdates)
+		released = result.RowsAffected
+		return result.Error
+	})
+
+	return released, err
+}
+
+func AssignWorkflowJobRun(id int64, repositoryId int64, vm *VM) *gorm.DB {
+	updates := &WorkflowJobRun{VMInstanceName: vm.VMInstanceName, VMHost: vm.Host, AssignedAt: sql.NullTime{Time: time.Now(), Valid: true}}
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ?", id, repositoryId).
+		Updates(updates)
+}
+
+func SetWorkflowJobRunCheckRun(id int64, repositoryId int64, checkRunId int64) *gorm.DB {
+	updates := &WorkflowJobRun{CheckRunId: sql.NullInt64{Int64: checkRunId, Valid: true}}
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ?", id, repositoryId).
+		Updates(updates)
+}
+
+func FailWorkflowJobRun(id int64, repositoryId int64, reason string) *gorm.DB {
+	updates := &WorkflowJobRun{FailureReason: sql.NullString{String: reason, Valid: true}}
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ?", id, repositoryId).
+		Updates(updates)
+}
+
+func EscalateWorkflowJobRun(id int64, repositoryId int64) *gorm.DB {
+	updates := &WorkflowJobRun{EscalatedAt: sql.NullTime{Time: time.Now(), Valid: true}}
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ?", id, repositoryId).
+		Updates(updates)
+}
+
 func KickoffWorkflowJobRun(id int64, repositoryId int64) *gorm.DB {
 	updates := &WorkflowJobRun{KickoffAt: sql.NullTime{Time: time.Now(), Valid: true}}
 	return db.DB.
@@ -232,6 +498,7 @@ func ProcessWorkflowJobRun(id int64, repositoryId int64, status string) *gorm.DB
 		Updates(updates)
 }
 
+// CompleteWorkflowJobRun closes the run with GitHub's outcome, unless it was already closed by us, e.g. timed out
 func CompleteWorkflowJobRun(id int64, repositoryId int64, status string, conclusion string, endedAt time.Time) *gorm.DB {
 	var c sql.NullString
 
@@ -244,21 +511,186 @@ func CompleteWorkflowJobRun(id int64, repositoryId int64, status string, conclus
 	updates := &WorkflowJobRun{Status: status, Conclusion: c, EndedAt: sql.NullTime{Time: endedAt, Valid: true}}
 	return db.DB.
 		Model(&WorkflowJobRun{}).
-		Where("id = ? AND repository_id = ?", id, repositoryId).
+		Where("id = ? AND repository_id = ? AND ended_at IS NULL", id, repositoryId).
+		Updates(updates)
+}
+
+// TimeOutWorkflowJobRun closes a run that held its VM past the maximum runtime
+func TimeOutWorkflowJobRun(id int64, repositoryId int64, reason string) *gorm.DB {
+	updates := &WorkflowJobRun{
+		Status:        "completed",
+		Conclusion:    sql.NullString{String: TimedOutConclusion, Valid: true},
+		FailureReason: sql.NullString{String: reason, Valid: true},
+		EndedAt:       sql.NullTime{Time: time.Now(), Valid: true},
+	}
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ? AND ended_at IS NULL", id, repositoryId).
 		Updates(updates)
 }
 
+// RunStatusCancelling marks a queued run while GitHub is asked to cancel its workflow run, no scheduler claims it
+const RunStatusCancelling = "cancelling"
+
+// MarkWorkflowJobRunCancelling takes a queued run out of the queue, RowsAffected is 0 once a VM is assigned
+func MarkWorkflowJobRunCancelling(id int64, repositoryId int64) *gorm.DB {
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ? AND status = ? AND assigned_at IS NULL AND kickoff_at IS NULL AND ended_at IS NULL AND failure_reason IS NULL", id, repositoryId, "queued").
+		Update("status", RunStatusCancelling)
+}
+
+// CancelPendingWorkflowJobRun closes a run marked cancelling, GitHub's completed webhook may have closed it already
+func CancelPendingWorkflowJobRun(id int64, repositoryId int64) *gorm.DB {
+	updates := &WorkflowJobRun{
+		Status:     "completed",
+		Conclusion: sql.NullString{String: "cancelled", Valid: true},
+		EndedAt:    sql.NullTime{Time: time.Now(), Valid: true},
+	}
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ? AND status = ? AND ended_at IS NULL", id, repositoryId, RunStatusCancelling).
+		Updates(updates)
+}
+
+// RestorePendingWorkflowJobRun puts a run marked cancelling back in the queue, when GitHub refused to cancel it
+func RestorePendingWorkflowJobRun(id int64, repositoryId int64) *gorm.DB {
+	return db.DB.
+		Model(&WorkflowJobRun{}).
+		Where("id = ? AND repository_id = ? AND status = ? AND ended_at IS NULL", id, repositoryId, RunStatusCancelling).
+		Update("status", "queued")
+}
+
+// RunningWorkflowJobRuns are the runs that hold a VM and have not ended, with their repository and installation
+func RunningWorkflowJobRuns() ([]WorkflowJobRun, error) {
+	jobRuns := make([]WorkflowJobRun, 0)
+	result := db.DB.
+		Preload("Repository.Installation").
+		Where("assigned_at IS NOT NULL AND ended_at IS NULL AND failure_reason IS NULL").
+		Order("assigned_at ASC").
+		Find(&jobRuns)
+
+	return jobRuns, result.Error
+}
+
+// StoreWorkflowJobRunDurations persists the queue and run time of a run that has ended
+func StoreWorkflowJobRunDurations(jobRun *WorkflowJobRun) *gorm.DB {
+	queueTime, runTime := jobRun.Durations()
+	updates := &WorkflowJobRun{
+		QueueSeconds: sql.NullInt64{Int64: int64(queueTime.Seconds()), Valid: true},
+		RunSeconds:   sql.NullInt64{Int64: int64(runTime.Seconds()), Valid: true},
+	}
+
+	return db.DB.Model(jobRun).Updates(updates)
+}
+
 type VMLock struct {
 	Lock *gorm.DB
 	VM   *VM
 }
 
-func CreateVM(baseVMName string, runnerLabel string) *gorm.DB {
-	vm := VM{Status: VMAvailable, GithubRunnerLabel: runnerLabel, BaseVMName: baseVMName}
-	return db.DB.Create(&vm)
+func CreateVM(baseVMName string, runnerLabel string, host string, imageId sql.NullInt64) (*gorm.DB, VM) {
+	vm := VM{Status: VMAvailable, GithubRunnerLabel: runnerLabel, BaseVMName: baseVMName, Host: host, ImageId: imageId}
+	return db.DB.Create(&vm), vm
+}
+
+// UnbindVMs takes the host's free VMs parked from the base VM out of the pool, and counts the busy ones left behind
+func UnbindVMs(baseVMName string, host string) ([]VM, int64, error) {
+	removed := make([]VM, 0)
+	var busy int64
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Returning{}).
+			Where("base_vm_name = ? AND host = ? AND status = ?", baseVMName, host, VMAvailable).
+			Delete(&removed)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Model(&VM{}).
+			Where("base_vm_name = ? AND host = ? AND status <> ?", baseVMName, host, VMAvailable).
+			Count(&busy).Error
+	})
+
+	return removed, busy, err
+}
+
+func BaseVMNames() ([]string, error) {
+	names := make([]string, 0)
+	result := db.DB.Model(&VM{}).Distinct().Pluck("base_vm_name", &names)
+
+	return names, result.Error
+}
+
+func VMInstanceExists(instanceName string) (bool, error) {
+	var count int64
+	result := db.DB.Model(&VM{}).Where("vm_instance_name = ?", instanceName).Count(&count)
+
+	return count > 0, result.Error
+}
+
+// Labels are the comma-separated runner labels the VM is registered with
+func (vm VM) Labels() []string {
+	return labels.Parse(vm.GithubRunnerLabel)
+}
+
+// Usable is false when the VM's image carries a runner version GitHub no longer accepts
+func (vm VM) Usable() bool {
+	return vm.Image == nil || !vm.Image.RunnerDead()
+}
+
+func FetchVMs() ([]VM, error) {
+	vms := make([]VM, 0)
+	result := db.DB.Preload("Image").Order("id ASC").Find(&vms)
+
+	return vms, result.Error
 }
 
-func InaugurateVM() (*VMLock, error) {
+// RunnerLabelSets are the label sets of the registered images and of VMs parked without one
+func RunnerLabelSets() ([][]string, error) {
+	values := make([]string, 0)
+	result := db.DB.Model(&Image{}).Distinct().Where("labels <> ''").Order("labels").Pluck("labels", &values)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	legacy := make([]string, 0)
+	result = db.DB.Model(&VM{}).Distinct().
+		Where("image_id IS NULL AND github_runner_label <> ''").
+		Order("github_runner_label").
+		Pluck("github_runner_label", &legacy)
+	values = append(values, legacy...)
+
+	labelSets := make([][]string, 0, len(values))
+	seen := make(map[string]bool)
+	for _, value := range values {
+		labelSet := labels.Parse(value)
+		key := labels.Join(labelSet)
+		if len(labelSet) == 0 || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		labelSets = append(labelSets, labelSet)
+	}
+
+	return labelSets, result.Error
+}
+
+// RunnerLabels is every label any of our images is registered with
+func RunnerLabels() ([]string, error) {
+	labelSets, err := RunnerLabelSets()
+
+	all := make([]string, 0)
+	for _, labelSet := range labelSets {
+		all = append(all, labelSet...)
+	}
+
+	return labels.Normalize(all), err
+}
+
+// InaugurateVM locks an available VM of the host that satisfies every one of jobLabels
+func InaugurateVM(host string, jobId int64, jobLabels []string) (*VMLock, error) {
 	vmLock := VMLock{Lock: db.DB.Begin(), VM: &VM{}}
 	defer func() {
 		if r := recover(); r != nil {
@@ -266,7 +698,7 @@ func InaugurateVM() (*VMLock, error) {
 		}
 	}()
 
-	result := vmLock.Start()
+	result := vmLock.Start(host, jobId, jobLabels)
 	if result.Error != nil {
 		vmLock.Close()
 		return nil, result.Error
@@ -277,24 +709,50 @@ func InaugurateVM() (*VMLock, error) {
 
 func FreeVM(vm *VM) *gorm.DB {
 	updates := map[string]interface{}{
-		"external_run_id":  gorm.Expr("NULL"),
-		"repository_id":    gorm.Expr("NULL"),
-		"vm_instance_name": gorm.Expr("NULL"),
-		"status":           VMAvailable,
+		"workflow_job_run_id": gorm.Expr("NULL"),
+		"repository_id":       gorm.Expr("NULL"),
+		"vm_instance_name":    gorm.Expr("NULL"),
+		"status":              VMAvailable,
 	}
 
 	return db.DB.Model(vm).Updates(updates)
 }
 
+// RunHoldsVM is whether a VM is still bound to the run, by the run's internal id
+func RunHoldsVM(jobRunInternalId int64) (bool, error) {
+	var count int64
+	result := db.DB.Model(&VM{}).Where("workflow_job_run_id = ?", jobRunInternalId).Count(&count)
+
+	return count > 0, result.Error
+}
+
+// RequestVMTeardown hands the VM to the scheduler of its host to tear down
+func RequestVMTeardown(tx *gorm.DB, vm *VM) *gorm.DB {
+	return tx.Model(vm).Update("status", VMPurging)
+}
+
+// VMsToTearDown are the host's VMs waiting to be torn down
+func VMsToTearDown(host string) ([]VM, error) {
+	vms := make([]VM, 0)
+	result := db.DB.
+		Where("host = ?", host).
+		Where("status = ? OR (status = ? AND (workflow_job_run_id IS NULL OR workflow_job_run_id IN (?)))",
+			VMPurging, VMProcessing, db.DB.Model(&WorkflowJobRun{}).Select("internal_id").Where("ended_at IS NOT NULL")).
+		Order("id ASC").
+		Find(&vms)
+
+	return vms, result.Error
+}
+
 func (vmLock *VMLock) Assign(instanceName string) *gorm.DB {
 	return vmLock.Lock.Model(&vmLock.VM).Update("vm_instance_name", instanceName)
 }
 
-func (vmLock *VMLock) Commit(runId int64, repositoryInternalId int64) {
+func (vmLock *VMLock) Commit(jobRunInternalId int64, repositoryInternalId int64) {
 	updates := VM{
-		Status:        VMProcessing,
-		ExternalRunId: sql.NullInt64{Int64: runId, Valid: true},
-		RepositoryId:  sql.NullInt64{Int64: repositoryInternalId, Valid: true},
+		Status:           VMProcessing,
+		WorkflowJobRunId: sql.NullInt64{Int64: jobRunInternalId, Valid: true},
+		RepositoryId:     sql.NullInt64{Int64: repositoryInternalId, Valid: true},
 	}
 
 	vmLock.Lock.Model(&vmLock.VM).Updates(updates)
@@ -305,6 +763,178 @@ func (vmLock *VMLock) Close() {
 	vmLock.Lock.Rollback()
 }
 
-func (vmLock *VMLock) Start() *gorm.DB {
-	return db.DB.Clauses(clause.Locking{Strength: "UPDATE"}).Where("status = ?", VMAvailable).First(&vmLock.VM)
+func (vmLock *VMLock) Start(host string, jobId int64, jobLabels []string) *gorm.DB {
+	available := make([]VM, 0)
+	result := vmLock.Lock.Preload("Image").
+		Where("status = ? AND host = ? AND host NOT IN (?)", VMAvailable, host, drainedHosts()).
+		Order("id ASC").
+		Find(&available)
+	if result.Error != nil {
+		return result
+	}
+
+	rollouts, err := FetchRollouts()
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	rollout := RolloutFor(rollouts, jobLabels)
+
+	preferred, fallback := make([]int64, 0), make([]int64, 0)
+	for _, vm := range available {
+		if !labels.Satisfies(vm.Labels(), jobLabels) || !vm.Usable() {
+			continue
+		}
+
+		if rollout == nil || rollout.Prefers(vm.ImageId, jobId) {
+			preferred = append(preferred, vm.Id)
+		} else if rollout.FallsBack(vm.ImageId, jobId) {
+			fallback = append(fallback, vm.Id)
+		}
+	}
+
+	for _, candidates := range [][]int64{preferred, fallback} {
+		if len(candidates) == 0 {
+			continue
+		}
+
+		result = vmLock.Lock.
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id IN ? AND status = ?", candidates, VMAvailable).
+			Order("id ASC").
+			Limit(1).
+			Find(&vmLock.VM)
+		if result.Error != nil || result.RowsAffected > 0 {
+			return result
+		}
+	}
+
+	result.Error = gorm.ErrRecordNotFound
+	return result
+}
+
+// RunningJobCounts counts the busy VMs per installation and per repository, keyed by internal ids
+func RunningJobCounts() (map[int64]int, map[int64]int, error) {
+	var rows []struct {
+		InstallationId int64
+		RepositoryId   int64
+		Count          int
+	}
+
+	result := db.DB.
+		Model(&VM{}).
+		Select("repositories.installation_id, vms.repository_id, count(*) as count").
+		Joins("JOIN repositories ON repositories.internal_id = vms.repository_id").
+		Where("vms.status = ?", VMProcessing).
+		Group("repositories.installation_id, vms.repository_id").
+		Scan(&rows)
+
+	byInstallation, byRepository := make(map[int64]int), make(map[int64]int)
+	for _, row := range rows {
+		byInstallation[row.InstallationId] += row.Count
+		byRepository[row.RepositoryId] += row.Count
+	}
+
+	return byInstallation, byRepository, result.Error
+}
+
+// UpdateInstallationLimits sets the limits that are given, and leaves the others as they are
+func UpdateInstallationLimits(installation *Installation, maxConcurrentJobs *int, schedulingWeight *int, maxRuntimeMinutes *int) *gorm.DB {
+	updates := map[string]interface{}{}
+	if maxConcurrentJobs != nil {
+		updates["max_concurrent_jobs"] = *maxConcurrentJobs
+	}
+	if schedulingWeight != nil {
+		updates["scheduling_weight"] = *schedulingWeight
+	}
+	if maxRuntimeMinutes != nil {
+		updates["max_runtime_minutes"] = *maxRuntimeMinutes
+	}
+	if len(updates) == 0 {
+		return db.DB
+	}
+
+	return db.DB.Model(installation).Updates(updates)
+}
+
+// UpdateInstallationBudget sets the budget and clears the exhausted marker, so a raised budget is announced again if spent
+func UpdateInstallationBudget(installation *Installation, monthlyMinutes int64, softLimitPercent int, policy BudgetPolicy) *gorm.DB {
+	updates := map[string]interface{}{
+		"monthly_minute_budget": monthlyMinutes,
+		"soft_limit_percent":    softLimitPercent,
+		"budget_policy":         policy,
+		"budget_warned_for":     "",
+		"budget_exhausted_for":  "",
+	}
+
+	return db.DB.Model(installation).Updates(updates)
+}
+
+func MarkInstallationBudgetWarned(installation *Installation, month string) *gorm.DB {
+	return db.DB.Model(installation).Update("budget_warned_for", month)
+}
+
+func MarkInstallationBudgetExhausted(installation *Installation, month string) *gorm.DB {
+	return db.DB.Model(installation).Update("budget_exhausted_for", month)
+}
+
+func UpdateRepositoryLimits(repository *Repository, maxConcurrentJobs int) *gorm.DB {
+	return db.DB.Model(repository).Update("max_concurrent_jobs", maxConcurrentJobs)
+}
+
+func UpdateInstallationCapacityPolicy(installation *Installation, policy CapacityPolicy) *gorm.DB {
+	return db.DB.Model(installation).Update("capacity_policy", policy)
+}
+
+func CreateNotification(installationInternalId int64, kind string, message string) *gorm.DB {
+	notification := Notification{InstallationId: installationInternalId, Kind: kind, Message: message}
+	return db.DB.Create(&notification)
+}
+
+func FetchUnreadNotifications(installations []Installation) ([]Notification, error) {
+	notifications := make([]Notification, 0)
+	if len(installations) == 0 {
+		return notifications, nil
+	}
+
+	ids := make([]int64, 0, len(installations))
+	for _, installation := range installations {
+		ids = append(ids, installation.InternalId)
+	}
+
+	result := db.DB.
+		Preload("Installation").
+		Where("installation_id IN ? AND read_at IS NULL", ids).
+		Order("created_at DESC").
+		Limit(20).
+		Find(&notifications)
+
+	return notifications, result.Error
+}
+
+func DismissNotification(userId int64, notificationId int64) *gorm.DB {
+	return db.DB.
+		Model(&Notification{}).
+		Where("id = ? AND installation_id IN (?)", notificationId, db.DB.Model(&Membership{}).Select("installation_id").Where("user_id = ?", userId)).
+		Update("read_at", time.Now())
+}
+
+// PoolStatus is how many of the VMs with a label are free and busy
+type PoolStatus struct {
+	Label     string `json:"label"`
+	Available int64  `json:"available"`
+	Busy      int64  `json:"busy"`
+}
+
+func PoolSummary() ([]PoolStatus, error) {
+	pool := make([]PoolStatus, 0)
+	result := db.DB.Model(&VM{}).
+		Select("github_runner_label AS label, "+
+			"count(*) FILTER (WHERE status = ?) AS available, "+
+			"count(*) FILTER (WHERE status <> ?) AS busy", VMAvailable, VMAvailable).
+		Group("github_runner_label").
+		Order("github_runner_label").
+		Scan(&pool)
+
+	return pool, result.Error
 }
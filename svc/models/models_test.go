@@ -0,0 +1,31 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestWorkflowJobRunDurations(t *testing.T) {
+	queued := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) sql.NullTime {
+		return sql.NullTime{Time: queued.Add(time.Duration(minutes) * time.Minute), Valid: true}
+	}
+	cases := []struct {
+		name      string
+		run       WorkflowJobRun
+		wantQueue time.Duration
+		wantRun   time.Duration
+	}{
+		{"ended after running", WorkflowJobRun{StartedAt: queued, ProcessingAt: at(5), EndedAt: at(25)}, 5 * time.Minute, 20 * time.Minute},
+		{"ended without running", WorkflowJobRun{StartedAt: queued, EndedAt: at(7)}, 7 * time.Minute, 0},
+		{"stored", WorkflowJobRun{StartedAt: queued, ProcessingAt: at(5), EndedAt: at(25), QueueSeconds: sql.NullInt64{Int64: 60, Valid: true}, RunSeconds: sql.NullInt64{Int64: 120, Valid: true}}, time.Minute, 2 * time.Minute},
+	}
+
+	for _, c := range cases {
+		queueTime, runTime := c.run.Durations()
+		if queueTime != c.wantQueue || runTime != c.wantRun {
+			t.Errorf("%s: Durations = %s, %s, want %s, %s", c.name, queueTime, runTime, c.wantQueue, c.wantRun)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"buildkansen/db"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// activeUserIds, activeInstallationIds and activeRepositoryIds select the rows that are not soft deleted
+func activeUserIds() *gorm.DB {
+	return db.DB.Table("users").Select("id").Where("deleted_at IS NULL")
+}
+
+func activeInstallationIds() *gorm.DB {
+	return db.DB.Table("installations").Select("internal_id").Where("deleted_at IS NULL")
+}
+
+func activeRepositoryIds() *gorm.DB {
+	return db.DB.Table("repositories").
+		Select("repositories.internal_id").
+		Where("repositories.deleted_at IS NULL AND repositories.installation_id IN (?)", activeInstallationIds())
+}
+
+// ownedInstallationIds is a subquery of the installations the user owns
+func ownedInstallationIds(tx *gorm.DB, userId int64) *gorm.DB {
+	return tx.Model(&Membership{}).Select("installation_id").Where("user_id = ? AND role = ?", userId, RoleOwner)
+}
+
+// RestoreUser brings back a user deleted since the time, with the installations deleted along with them
+func RestoreUser(userId int64, since time.Time) (bool, error) {
+	restored := false
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		user := User{}
+		result := tx.Unscoped().Where("id = ? AND deleted_at >= ?", userId, since).First(&user)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Unscoped().Model(&Installation{}).
+			Where("deleted_at = ? AND internal_id IN (?)", user.DeletedAt.Time, ownedInstallationIds(tx, userId)).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Unscoped().Model(&user).Update("deleted_at", nil)
+		restored = result.RowsAffected > 0
+		return result.Error
+	})
+
+	return restored, err
+}
+
+// FetchDeletedInstallations returns the installations the user owns that were deleted since the time
+func FetchDeletedInstallations(userId int64, since time.Time) ([]Installation, error) {
+	installations := make([]Installation, 0)
+	result := db.DB.Unscoped().
+		Where("deleted_at >= ? AND internal_id IN (?)", since, ownedInstallationIds(db.DB, userId)).
+		Order("deleted_at DESC").
+		Find(&installations)
+
+	return installations, result.Error
+}
+
+// RestoreInstallation brings back an installation the user owns that was deleted since the time
+func RestoreInstallation(userId int64, internalId int64, since time.Time) *gorm.DB {
+	return db.DB.Unscoped().Model(&Installation{}).
+		Where("internal_id = ? AND deleted_at >= ?", internalId, since).
+		Where("internal_id IN (?)", ownedInstallationIds(db.DB, userId)).
+		Update("deleted_at", nil)
+}
+
+// RestoreAdministeredInstallation brings back an installation deleted since the time
+func RestoreAdministeredInstallation(tx *gorm.DB, internalId int64, since time.Time) *gorm.DB {
+	return tx.Unscoped().Model(&Installation{}).
+		Where("internal_id = ? AND deleted_at >= ?", internalId, since).
+		Update("deleted_at", nil)
+}
+
+// FindDeletedInstallation looks up a soft deleted installation by its GitHub id
+func FindDeletedInstallation(id int64) (*Installation, error) {
+	installation := Installation{}
+	result := db.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&installation)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &installation, nil
+}
+
+// PurgeDeleted removes the rows deleted before the cutoff for good and returns how many it removed
+func PurgeDeleted(cutoff time.Time) (int64, error) {
+	var purged int64
+	for _, model := range []interface{}{&Repository{}, &Installation{}, &User{}} {
+		result := db.DB.Unscoped().Where("deleted_at < ?", cutoff).Delete(model)
+		if result.Error != nil {
+			return purged, result.Error
+		}
+		purged += result.RowsAffected
+	}
+
+	return purged, nil
+}
+
+// PurgeRunsEndedBefore removes the runs that ended before the cutoff, their usage records stay for billing
+func PurgeRunsEndedBefore(cutoff time.Time) *gorm.DB {
+	return db.DB.Where("ended_at < ?", cutoff).Delete(&WorkflowJobRun{})
+}
+
+// FetchOwnedInstallations returns the installations the user owns, with their repositories
+func FetchOwnedInstallations(userId int64) ([]Installation, error) {
+	installations := make([]Installation, 0)
+	result := db.DB.
+		Preload("Repositories").
+		Where("internal_id IN (?)", ownedInstallationIds(db.DB, userId)).
+		Order("internal_id").
+		Find(&installations)
+
+	return installations, result.Error
+}
+
+// InstallationUsageRecords returns every usage record of the installations
+func InstallationUsageRecords(installationIds []int64) ([]UsageRecord, error) {
+	records := make([]UsageRecord, 0)
+	result := db.DB.
+		Where("installation_id IN ?", installationIds).
+		Order("started_at").
+		Find(&records)
+
+	return records, result.Error
+}
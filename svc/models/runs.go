@@ -0,0 +1,162 @@
+package models
+
+import (
+	"buildkansen/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RunSortStarted   = "started"
+	RunSortQueueTime = "queue_time"
+	RunSortRunTime   = "run_time"
+
+	// RunStatusInfrastructureFailure filters for runs we failed to run, it is not a status GitHub reports
+	RunStatusInfrastructureFailure = "infrastructure_failure"
+)
+
+// RunFilter narrows and orders the job history, zero values do not filter
+type RunFilter struct {
+	RepositoryId int64
+	WorkflowName string
+	Status       string
+	Conclusion   string
+	Label        string
+	From         time.Time
+	To           time.Time
+	Sort         string
+	Ascending    bool
+	Page         int
+	PerPage      int
+}
+
+// UserInstallationIds are the internal ids of the installations whose data the user may see
+func UserInstallationIds(user *User) ([]int64, error) {
+	ids := make([]int64, 0)
+	result := db.DB.Model(&Membership{}).
+		Where("user_id = ? AND installation_id IN (?)", user.Id, activeInstallationIds()).
+		Pluck("installation_id", &ids)
+
+	return ids, result.Error
+}
+
+// FetchRuns returns a page of the installations' runs matching the filter, and how many runs match in total
+func FetchRuns(installationIds []int64, filter RunFilter) ([]WorkflowJobRun, int64, error) {
+	runs := make([]WorkflowJobRun, 0)
+	query := db.DB.Model(&WorkflowJobRun{}).
+		Joins("JOIN repositories ON repositories.internal_id = workflow_job_runs.repository_id").
+		Where("repositories.installation_id IN ?", installationIds)
+
+	if filter.RepositoryId != 0 {
+		query = query.Where("workflow_job_runs.repository_id = ?", filter.RepositoryId)
+	}
+	if len(filter.WorkflowName) > 0 {
+		query = query.Where("workflow_job_runs.workflow_name = ?", filter.WorkflowName)
+	}
+	if filter.Status == RunStatusInfrastructureFailure {
+		query = query.Where("workflow_job_runs.failure_reason IS NOT NULL")
+	} else if len(filter.Status) > 0 {
+		query = query.Where("workflow_job_runs.status = ?", filter.Status)
+	}
+	if len(filter.Conclusion) > 0 {
+		query = query.Where("workflow_job_runs.conclusion = ?", filter.Conclusion)
+	}
+	if len(filter.Label) > 0 {
+		query = query.Where("',' || workflow_job_runs.labels || ',' LIKE ?", "%,"+filter.Label+",%")
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("workflow_job_runs.started_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("workflow_job_runs.started_at < ?", filter.To)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	result := query.Count(&total)
+	if result.Error != nil {
+		return runs, 0, result.Error
+	}
+
+	query = query.Preload("Repository").Order(filter.order())
+	if filter.PerPage > 0 {
+		query = query.Offset((filter.Page - 1) * filter.PerPage).Limit(filter.PerPage)
+	}
+
+	result = query.Find(&runs)
+
+	for i := range runs {
+		runs[i].QueueDuration, runs[i].RunDuration = runs[i].Durations()
+	}
+
+	return runs, total, result.Error
+}
+
+func (filter RunFilter) order() string {
+	direction := "DESC"
+	if filter.Ascending {
+		direction = "ASC"
+	}
+
+	var column string
+	switch filter.Sort {
+	case RunSortQueueTime:
+		column = "coalesce(workflow_job_runs.processing_at, now()) - workflow_job_runs.started_at"
+	case RunSortRunTime:
+		column = "coalesce(workflow_job_runs.ended_at, now()) - workflow_job_runs.processing_at"
+	default:
+		column = "workflow_job_runs.started_at"
+	}
+
+	return column + " " + direction + " NULLS LAST, workflow_job_runs.internal_id " + direction
+}
+
+// FindRun returns one of the installations' runs by its internal id, with its repository and installation
+func FindRun(installationIds []int64, internalId int64) (*WorkflowJobRun, error) {
+	jobRun := WorkflowJobRun{}
+	result := db.DB.
+		Preload("Repository.Installation").
+		Joins("JOIN repositories ON repositories.internal_id = workflow_job_runs.repository_id").
+		Where("workflow_job_runs.internal_id = ? AND repositories.installation_id IN ?", internalId, installationIds).
+		First(&jobRun)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	jobRun.QueueDuration, jobRun.RunDuration = jobRun.Durations()
+	return &jobRun, nil
+}
+
+// RunAttempts are every attempt GitHub made at the run's job, the run itself included, first attempt first
+func RunAttempts(jobRun *WorkflowJobRun) ([]WorkflowJobRun, error) {
+	attempts := make([]WorkflowJobRun, 0)
+	result := db.DB.
+		Where("repository_id = ? AND workflow_run_id = ? AND name = ?", jobRun.RepositoryId, jobRun.WorkflowRunId, jobRun.Name).
+		Order("run_attempt ASC, started_at ASC").
+		Find(&attempts)
+
+	return attempts, result.Error
+}
+
+// InstallationRepositories are the installations' repositories, by name
+func InstallationRepositories(installationIds []int64) ([]Repository, error) {
+	repositories := make([]Repository, 0)
+	result := db.DB.Where("installation_id IN ?", installationIds).Order("full_name").Find(&repositories)
+
+	return repositories, result.Error
+}
+
+// RunWorkflowNames are the distinct workflow names the installations have run, to filter by
+func RunWorkflowNames(installationIds []int64) ([]string, error) {
+	names := make([]string, 0)
+	result := db.DB.Model(&WorkflowJobRun{}).
+		Joins("JOIN repositories ON repositories.internal_id = workflow_job_runs.repository_id").
+		Where("repositories.installation_id IN ?", installationIds).
+		Distinct("workflow_job_runs.workflow_name").
+		Order("workflow_job_runs.workflow_name").
+		Pluck("workflow_job_runs.workflow_name", &names)
+
+	return names, result.Error
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"buildkansen/db"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UsageRecord is the metered usage of one job, written once its VM is torn down or, if the VM is gone, once it ends
+type UsageRecord struct {
+	Id int64 `gorm:"primaryKey"`
+	// WorkflowJobRunId is the internal id of the run, it is unset once the run is purged and the record stays
+	WorkflowJobRunId sql.NullInt64 `gorm:"uniqueIndex"`
+	InstallationId   int64         `gorm:"index"`
+	Installation     Installation  `gorm:"foreignKey:InstallationId;references:InternalId;constraint:OnDelete:CASCADE"`
+	RepositoryId     int64
+	// JobId, Repository, WorkflowName and JobName are copied from the run, so they outlive it
+	JobId        int64
+	Repository   string
+	WorkflowName string
+	JobName      string
+	Label        string
+	Host         string
+	// VMSeconds is how long a VM was held for the job, from assignment to teardown, or to the job's end if the VM is gone
+	VMSeconds int64
+	// BillableMinutes is the job's run time rounded up to the whole minute, as GitHub bills it
+	BillableMinutes int64
+	StartedAt       time.Time `gorm:"index"`
+	EndedAt         time.Time
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+}
+
+// UsageRollup is usage summed over a month, per installation, label and host
+type UsageRollup struct {
+	InstallationId  int64  `json:"-"`
+	AccountLogin    string `json:"account_login"`
+	GithubId        int64  `json:"installation_id"`
+	Label           string `json:"label"`
+	Host            string `json:"host"`
+	Jobs            int64  `json:"jobs"`
+	VMSeconds       int64  `json:"vm_seconds"`
+	BillableMinutes int64  `json:"billable_minutes"`
+}
+
+// BillableMinutesUsed sums the installation's billable minutes in the calendar month of t
+func BillableMinutesUsed(installationId int64, t time.Time) (int64, error) {
+	var used int64
+	result := usageInMonth(t, installationId).Select("coalesce(sum(usage_records.billable_minutes), 0)").Scan(&used)
+
+	return used, result.Error
+}
+
+func CreateUsageRecord(record *UsageRecord) *gorm.DB {
+	return db.DB.Create(record)
+}
+
+// usageInMonth scopes usage records to jobs that started in the calendar month of t, and to an installation if given
+func usageInMonth(t time.Time, installationId int64) *gorm.DB {
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	query := db.DB.Model(&UsageRecord{}).Where("usage_records.started_at >= ? AND usage_records.started_at < ?", from, from.AddDate(0, 1, 0))
+
+	if installationId != 0 {
+		query = query.Where("usage_records.installation_id = ?", installationId)
+	}
+
+	return query
+}
+
+// MonthlyUsage rolls the month's usage up per installation, label and host; installationId 0 is all of them
+func MonthlyUsage(month time.Time, installationId int64) ([]UsageRollup, error) {
+	rollups := make([]UsageRollup, 0)
+	result := usageInMonth(month, installationId).
+		Select("usage_records.installation_id, installations.account_login, installations.id as github_id, " +
+			"usage_records.label, usage_records.host, count(*) as jobs, " +
+			"sum(usage_records.vm_seconds) as vm_seconds, sum(usage_records.billable_minutes) as billable_minutes").
+		Joins("JOIN installations ON installations.internal_id = usage_records.installation_id").
+		Group("usage_records.installation_id, installations.account_login, installations.id, usage_records.label, usage_records.host").
+		Order("installations.account_login, usage_records.label, usage_records.host").
+		Scan(&rollups)
+
+	return rollups, result.Error
+}
+
+// MonthlyUsageRecords returns the month's per-job records, with their installation loaded
+func MonthlyUsageRecords(month time.Time, installationId int64) ([]UsageRecord, error) {
+	records := make([]UsageRecord, 0)
+	result := usageInMonth(month, installationId).
+		Preload("Installation").
+		Order("usage_records.started_at ASC").
+		Find(&records)
+
+	return records, result.Error
+}
@@ -0,0 +1,154 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// KeySize is the length of a keystore's key, it is AES-256
+const KeySize = 32
+
+// Keystore keeps the secrets in a local file encrypted with AES-GCM, the key is kept apart from it
+type Keystore struct {
+	path    string
+	key     []byte
+	secrets map[string]string
+}
+
+// sealed is the keystore on disk
+type sealed struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewKey is a random key for a keystore, base64 encoded
+func NewKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// OpenKeystore decrypts the keystore at path with the base64 encoded key, a keystore that doesn't exist yet is empty
+func OpenKeystore(path string, encodedKey string) (*Keystore, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("the keystore key must be %d base64 encoded bytes", KeySize)
+	}
+
+	keystore := &Keystore{path: path, key: key, secrets: make(map[string]string)}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return keystore, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s sealed
+	if err := json.Unmarshal(contents, &s); err != nil {
+		return nil, fmt.Errorf("the keystore %s is corrupt: %w", path, err)
+	}
+
+	gcm, err := keystore.cipher()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, s.Nonce, s.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt the keystore %s, is it the right key?", path)
+	}
+
+	if err := json.Unmarshal(plaintext, &keystore.secrets); err != nil {
+		return nil, fmt.Errorf("the keystore %s is corrupt: %w", path, err)
+	}
+
+	return keystore, nil
+}
+
+func (k *Keystore) Name() string {
+	return ProviderKeystore
+}
+
+func (k *Keystore) Lookup(name string) (string, bool, error) {
+	value, found := k.secrets[name]
+	return value, found, nil
+}
+
+// Names are the names of the secrets in the keystore, sorted
+func (k *Keystore) Names() []string {
+	names := make([]string, 0, len(k.secrets))
+	for name := range k.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Set changes the secret in memory, Save writes it
+func (k *Keystore) Set(name string, value string) {
+	k.secrets[name] = value
+}
+
+func (k *Keystore) Remove(name string) {
+	delete(k.secrets, name)
+}
+
+// Save encrypts the keystore with a fresh nonce and replaces the file, only its owner can read it
+func (k *Keystore) Save() error {
+	plaintext, err := json.Marshal(k.secrets)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := k.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(sealed{Nonce: nonce, Ciphertext: gcm.Seal(nil, nonce, plaintext, nil)})
+	if err != nil {
+		return err
+	}
+
+	// write next to it and rename, so a crash never leaves half a keystore
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), k.path)
+}
+
+func (k *Keystore) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newKeystore(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(t.TempDir(), "secrets.keystore"), key
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	path, key := newKeystore(t)
+
+	keystore, err := OpenKeystore(path, key)
+	if err != nil {
+		t.Fatalf("opening a keystore that doesn't exist yet: %s", err)
+	}
+	if len(keystore.Names()) != 0 {
+		t.Fatalf("a new keystore should be empty, has %v", keystore.Names())
+	}
+
+	keystore.Set("SESSION_SECRET", "s3cret")
+	keystore.Set("INTERNAL_API_TOKEN", "token")
+	keystore.Set("GONE", "soon")
+	keystore.Remove("GONE")
+	if err := keystore.Save(); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	reopened, err := OpenKeystore(path, key)
+	if err != nil {
+		t.Fatalf("reopening: %s", err)
+	}
+	if names := reopened.Names(); !reflect.DeepEqual(names, []string{"INTERNAL_API_TOKEN", "SESSION_SECRET"}) {
+		t.Errorf("Names = %v", names)
+	}
+
+	value, found, err := reopened.Lookup("SESSION_SECRET")
+	if err != nil || !found || value != "s3cret" {
+		t.Errorf("Lookup(SESSION_SECRET) = %q, %v, %v", value, found, err)
+	}
+	if _, found, _ := reopened.Lookup("GONE"); found {
+		t.Error("a removed secret should be gone")
+	}
+}
+
+func TestKeystoreIsEncrypted(t *testing.T) {
+	path, key := newKeystore(t)
+
+	keystore, _ := OpenKeystore(path, key)
+	keystore.Set("SESSION_SECRET", "plaintext-should-not-appear")
+	if err := keystore.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(contents), "plaintext-should-not-appear") || strings.Contains(string(contents), "SESSION_SECRET") {
+		t.Error("the keystore file holds the secrets in the clear")
+	}
+}
+
+func TestKeystoreWrongKey(t *testing.T) {
+	path, key := newKeystore(t)
+
+	keystore, _ := OpenKeystore(path, key)
+	keystore.Set("SESSION_SECRET", "s3cret")
+	if err := keystore.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, _ := NewKey()
+	if _, err := OpenKeystore(path, otherKey); err == nil {
+		t.Error("opening with another key should fail")
+	}
+}
+
+func TestKeystoreBadKey(t *testing.T) {
+	path, _ := newKeystore(t)
+
+	for _, key := range []string{"", "not base64!", "c2hvcnQ="} {
+		if _, err := OpenKeystore(path, key); err == nil {
+			t.Errorf("OpenKeystore should refuse the key %q", key)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "SESSION_SECRET"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	value, found, err := Files{Dir: dir}.Lookup("SESSION_SECRET")
+	if err != nil || !found || value != "s3cret" {
+		t.Errorf("Lookup(SESSION_SECRET) = %q, %v, %v", value, found, err)
+	}
+
+	if _, found, err := (Files{Dir: dir}).Lookup("MISSING"); found || err != nil {
+		t.Errorf("a missing file should not be found and not be an error, got %v, %v", found, err)
+	}
+}
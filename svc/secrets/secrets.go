@@ -0,0 +1,53 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ProviderEnv      = "env"
+	ProviderFile     = "file"
+	ProviderKeystore = "keystore"
+)
+
+// Provider looks secrets up by their setting's name, found is false when it doesn't have the secret
+type Provider interface {
+	Name() string
+	Lookup(name string) (value string, found bool, err error)
+}
+
+// Env reads secrets from the environment like any other setting
+type Env struct{}
+
+func (Env) Name() string {
+	return ProviderEnv
+}
+
+func (Env) Lookup(name string) (string, bool, error) {
+	value, found := os.LookupEnv(name)
+	return value, found, nil
+}
+
+// Files reads each secret from the file named after it in Dir, the way secrets are mounted into containers
+type Files struct {
+	Dir string
+}
+
+func (Files) Name() string {
+	return ProviderFile
+}
+
+func (f Files) Lookup(name string) (string, bool, error) {
+	contents, err := os.ReadFile(filepath.Join(f.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return strings.TrimSpace(string(contents)), true, nil
+}
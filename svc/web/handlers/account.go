@@ -0,0 +1,163 @@
+package web
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// HandleAccountExport downloads everything we hold about the user as JSON
+func HandleAccountExport(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	if _, impersonating := c.Get("impersonator"); impersonating {
+		c.String(http.StatusForbidden, "Only the user can export their data")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	memberships, err := models.FetchMemberships(user.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to export your data")
+		return
+	}
+	apiTokens, err := models.FetchApiTokens(user.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to export your data")
+		return
+	}
+	auditEvents, _, err := models.FetchAuditEvents(models.AuditFilter{ActorId: user.Id})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to export your data")
+		return
+	}
+	owned, err := models.FetchOwnedInstallations(user.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to export your data")
+		return
+	}
+
+	installations := make([]gin.H, 0, len(owned))
+	for _, installation := range owned {
+		exported, err := exportInstallation(&installation)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Failed to export your data")
+			return
+		}
+		installations = append(installations, exported)
+	}
+
+	exportedMemberships := make([]gin.H, 0, len(memberships))
+	for _, membership := range memberships {
+		exportedMemberships = append(exportedMemberships, gin.H{
+			"installation_id": membership.Installation.Id,
+			"account_login":   membership.Installation.AccountLogin,
+			"role":            membership.Role,
+			"created_at":      membership.CreatedAt,
+		})
+	}
+
+	exportedTokens := make([]gin.H, 0, len(apiTokens))
+	for _, token := range apiTokens {
+		exportedTokens = append(exportedTokens, gin.H{
+			"name":         token.Name,
+			"prefix":       token.Prefix,
+			"scopes":       token.Scopes,
+			"created_at":   token.CreatedAt,
+			"expires_at":   nullTime(token.ExpiresAt.Time, token.ExpiresAt.Valid),
+			"last_used_at": nullTime(token.LastUsedAt.Time, token.LastUsedAt.Valid),
+			"revoked_at":   nullTime(token.RevokedAt.Time, token.RevokedAt.Valid),
+		})
+	}
+
+	exportedEvents := make([]gin.H, 0, len(auditEvents))
+	for _, event := range auditEvents {
+		exportedEvents = append(exportedEvents, gin.H{
+			"action":      event.Action,
+			"target_type": event.TargetType,
+			"target_id":   event.TargetId,
+			"ip_address":  event.IpAddress,
+			"user_agent":  event.UserAgent,
+			"created_at":  event.CreatedAt,
+		})
+	}
+
+	audit(c, "account.export", core.UserTarget(user.Id), nil, nil)
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=buildkansen-%d.json", user.Id))
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"exported_at": time.Now().UTC(),
+		"user": gin.H{
+			"id":         user.Id,
+			"login":      user.Login,
+			"name":       user.Name,
+			"email":      user.Email,
+			"created_at": user.CreatedAt,
+		},
+		"memberships":         exportedMemberships,
+		"api_tokens":          exportedTokens,
+		"audit_events":        exportedEvents,
+		"owned_installations": installations,
+	})
+}
+
+func exportInstallation(installation *models.Installation) (gin.H, error) {
+	runs, _, err := models.FetchRuns([]int64{installation.InternalId}, models.RunFilter{Ascending: true})
+	if err != nil {
+		return nil, err
+	}
+	records, err := models.InstallationUsageRecords([]int64{installation.InternalId})
+	if err != nil {
+		return nil, err
+	}
+
+	repositories := make([]string, 0, len(installation.Repositories))
+	for _, repository := range installation.Repositories {
+		repositories = append(repositories, repository.FullName)
+	}
+
+	exportedRuns := make([]runResponse, 0, len(runs))
+	for i := range runs {
+		exportedRuns = append(exportedRuns, newRunResponse(&runs[i]))
+	}
+
+	usage := make([]gin.H, 0, len(records))
+	for _, record := range records {
+		usage = append(usage, gin.H{
+			"job_id":           record.JobId,
+			"repository":       record.Repository,
+			"label":            record.Label,
+			"host":             record.Host,
+			"started_at":       record.StartedAt,
+			"ended_at":         record.EndedAt,
+			"vm_seconds":       record.VMSeconds,
+			"billable_minutes": record.BillableMinutes,
+		})
+	}
+
+	return gin.H{
+		"installation_id": installation.Id,
+		"account_login":   installation.AccountLogin,
+		"account_type":    installation.AccountType,
+		"settings":        installationSettings(installation),
+		"repositories":    repositories,
+		"runs":            exportedRuns,
+		"usage":           usage,
+	}, nil
+}
+
+// nullTime is the time, or nil when it isn't set, for JSON
+func nullTime(t time.Time, valid bool) *time.Time {
+	if !valid {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,183 @@
+package web
+
+import (
+	"buildkansen/config"
+	"buildkansen/internal/app_error"
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	mw "buildkansen/web/middleware"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// HandleAdmin shows operators every installation, the hosts and VMs of the pool, and the queue
+func HandleAdmin(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	isProduction, _ := c.Get("isProduction")
+
+	installations, err := models.FetchAllInstallations()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to fetch the installations")
+		return
+	}
+
+	members, _ := models.FetchInstallationMembers(installationIds(installations))
+	hosts, _ := models.FetchHostStatuses()
+	vms, _ := models.FetchVMs()
+	vmRuns, _ := models.OpenRunsByVMInstance()
+	queue, _ := models.PendingWorkflowJobRuns()
+	running, _ := models.RunningWorkflowJobRuns()
+	stranded, _ := models.StrandedWorkflowJobRuns()
+
+	c.HTML(http.StatusOK, "admin.html", withSession(c, gin.H{
+		"user":          user,
+		"installations": installations,
+		"members":       members,
+		"minutesUsed":   core.MinutesUsed(installations),
+		"hosts":         hosts,
+		"vms":           vms,
+		"vmRuns":        vmRuns,
+		"queue":         queue,
+		"running":       running,
+		"stranded":      stranded,
+		"isProduction":  isProduction.(bool),
+	}))
+}
+
+func AdminDrainHost(c *gin.Context) {
+	host := c.Param("name")
+	adminRedirect(c, core.SetHostDrained(host, true), "host.drain", core.HostTarget(host), nil)
+}
+
+func AdminUndrainHost(c *gin.Context) {
+	host := c.Param("name")
+	adminRedirect(c, core.SetHostDrained(host, false), "host.undrain", core.HostTarget(host), nil)
+}
+
+// AdminFreeVM returns a VM to the pool as is, AdminPurgeVM has the scheduler of its host tear its guest down first
+func AdminFreeVM(c *gin.Context) {
+	vm, ok := findAdminVM(c)
+	if !ok {
+		return
+	}
+
+	adminRedirect(c, core.ForceFreeVM(vm), "vm.free", core.VMTarget(vm), core.VMSnapshot(vm))
+}
+
+func AdminPurgeVM(c *gin.Context) {
+	vm, ok := findAdminVM(c)
+	if !ok {
+		return
+	}
+
+	adminRedirect(c, core.RequestTeardown(vm), "vm.purge", core.VMTarget(vm), core.VMSnapshot(vm))
+}
+
+func AdminRequeueRun(c *gin.Context) {
+	jobRun, ok := findAdminRun(c)
+	if !ok {
+		return
+	}
+
+	adminRedirect(c, core.RequeueJob(jobRun), "run.requeue", core.RunTarget(jobRun), core.RunSnapshot(jobRun))
+}
+
+func AdminCancelRun(c *gin.Context) {
+	jobRun, ok := findAdminRun(c)
+	if !ok {
+		return
+	}
+
+	adminRedirect(c, core.CancelJob(jobRun), "run.cancel", core.RunTarget(jobRun), core.RunSnapshot(jobRun))
+}
+
+// AdminImpersonate shows the operator the user's dashboard, read-only, until they stop
+func AdminImpersonate(c *gin.Context) {
+	userId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "User not found")
+		return
+	}
+
+	if _, err := models.FindEntityById(models.User{}, userId); err != nil {
+		c.String(http.StatusNotFound, "User not found")
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(config.C.ImpersonatedUserInSessionKey, userId)
+	_ = session.Save()
+
+	audit(c, "user.impersonate", core.UserTarget(userId), nil, nil)
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+func AdminStopImpersonating(c *gin.Context) {
+	session := sessions.Default(c)
+	if userId, ok := session.Get(config.C.ImpersonatedUserInSessionKey).(int64); ok {
+		audit(c, "user.impersonate_stop", core.UserTarget(userId), nil, nil)
+	}
+	session.Delete(config.C.ImpersonatedUserInSessionKey)
+	_ = session.Save()
+
+	c.Redirect(http.StatusFound, "/admin")
+}
+
+func findAdminVM(c *gin.Context) (*models.VM, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "VM not found")
+		return nil, false
+	}
+
+	vm, err := models.FindVM(id)
+	if err != nil {
+		c.String(http.StatusNotFound, "VM not found")
+		return nil, false
+	}
+
+	return vm, true
+}
+
+func findAdminRun(c *gin.Context) (*models.WorkflowJobRun, bool) {
+	internalId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Run not found")
+		return nil, false
+	}
+
+	jobRun, err := models.FindAnyRun(internalId)
+	if err != nil {
+		c.String(http.StatusNotFound, "Run not found")
+		return nil, false
+	}
+
+	return jobRun, true
+}
+
+// adminRedirect reports a failed action, or audits a successful one and goes back to the console
+func adminRedirect(c *gin.Context, appError *app_error.AppError, action string, target core.AuditTarget, before interface{}) {
+	if appError != nil {
+		c.String(appError.Code, appError.Message)
+		return
+	}
+
+	audit(c, action, target, before, nil)
+
+	c.Redirect(http.StatusFound, "/admin")
+}
+
+// withSession adds the signed in user and the CSRF token to the page's headers
+func withSession(c *gin.Context, headers gin.H) gin.H {
+	headers["csrfToken"] = mw.SessionToken(c, config.C.CsrfTokenInSessionKey)
+	if impersonator, exists := c.Get("impersonator"); exists {
+		headers["impersonator"] = impersonator
+	} else if user, ok := headers["user"].(models.User); ok {
+		headers["isAdmin"] = core.IsAdmin(&user)
+	}
+
+	return headers
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"buildkansen/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const defaultAnalyticsWindow = "7d"
+
+var analyticsWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"90d": 90 * 24 * time.Hour,
+}
+
+func HandleAnalytics(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	isProduction, _ := c.Get("isProduction")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	c.HTML(http.StatusOK, "analytics.html", withSession(c, gin.H{
+		"user":         userValue.(models.User),
+		"isProduction": isProduction.(bool),
+	}))
+}
+
+func AnalyticsDurations(c *gin.Context) {
+	installationIds, group, since, ok := analyticsScope(c)
+	if !ok {
+		return
+	}
+
+	stats, err := models.DurationPercentiles(installationIds, group, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute the durations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"group": group, "since": since, "durations": stats})
+}
+
+func AnalyticsThroughput(c *gin.Context) {
+	installationIds, _, since, ok := analyticsScope(c)
+	if !ok {
+		return
+	}
+
+	hours, err := models.JobsPerHour(installationIds, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count the jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"since": since, "hours": hours})
+}
+
+// AdminAnalyticsUtilisation is pool wide, hosts are shared by every installation, so only operators see it
+func AdminAnalyticsUtilisation(c *gin.Context) {
+	since, ok := analyticsSince(c)
+	if !ok {
+		return
+	}
+
+	hours, err := models.HostUtilisationByHour(since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute the utilisation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"since": since, "hours": hours})
+}
+
+func AnalyticsFailures(c *gin.Context) {
+	installationIds, group, since, ok := analyticsScope(c)
+	if !ok {
+		return
+	}
+
+	rates, err := models.JobFailureRates(installationIds, group, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute the failure rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"group": group, "since": since, "failures": rates})
+}
+
+// analyticsScope reads the group and window of an analytics request, and the installations the user may see
+func analyticsScope(c *gin.Context) ([]int64, string, time.Time, bool) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, "", time.Time{}, false
+	}
+
+	group := c.DefaultQuery("group", models.AnalyticsByLabel)
+	if !models.ValidAnalyticsGroup(group) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a group of label, repository or workflow"})
+		return nil, "", time.Time{}, false
+	}
+
+	since, ok := analyticsSince(c)
+	if !ok {
+		return nil, "", time.Time{}, false
+	}
+
+	user := userValue.(models.User)
+	installationIds, err := models.UserInstallationIds(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch your installations"})
+		return nil, "", time.Time{}, false
+	}
+
+	return installationIds, group, since, true
+}
+
+// analyticsSince is the start of an analytics request's window
+func analyticsSince(c *gin.Context) (time.Time, bool) {
+	window, found := analyticsWindows[c.DefaultQuery("window", defaultAnalyticsWindow)]
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a window of 24h, 7d, 30d or 90d"})
+		return time.Time{}, false
+	}
+
+	return time.Now().Add(-window), true
+}
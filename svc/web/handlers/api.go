@@ -0,0 +1,186 @@
+package web
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxApiRunsPerPage = 100
+
+// runResponse is a run as the public API shows it
+type runResponse struct {
+	Id             int64      `json:"id"`
+	JobId          int64      `json:"job_id"`
+	Name           string     `json:"name"`
+	Url            string     `json:"url"`
+	WorkflowName   string     `json:"workflow_name"`
+	WorkflowRunId  int64      `json:"workflow_run_id"`
+	RunAttempt     int        `json:"run_attempt"`
+	Repository     string     `json:"repository"`
+	HeadSha        string     `json:"head_sha"`
+	HeadBranch     string     `json:"head_branch"`
+	Labels         string     `json:"labels"`
+	Status         string     `json:"status"`
+	Conclusion     string     `json:"conclusion,omitempty"`
+	FailureReason  string     `json:"failure_reason,omitempty"`
+	VMInstanceName string     `json:"vm_instance_name,omitempty"`
+	VMHost         string     `json:"vm_host,omitempty"`
+	QueuedAt       time.Time  `json:"queued_at"`
+	StartedAt      *time.Time `json:"started_at"`
+	EndedAt        *time.Time `json:"ended_at"`
+	QueueSeconds   int64      `json:"queue_seconds"`
+	RunSeconds     int64      `json:"run_seconds"`
+}
+
+func newRunResponse(jobRun *models.WorkflowJobRun) runResponse {
+	response := runResponse{
+		Id:             jobRun.InternalId,
+		JobId:          jobRun.Id,
+		Name:           jobRun.Name,
+		Url:            jobRun.Url,
+		WorkflowName:   jobRun.WorkflowName,
+		WorkflowRunId:  jobRun.WorkflowRunId,
+		RunAttempt:     jobRun.RunAttempt,
+		Repository:     jobRun.Repository.FullName,
+		HeadSha:        jobRun.HeadSha,
+		HeadBranch:     jobRun.HeadBranch,
+		Labels:         jobRun.Labels,
+		Status:         jobRun.Status,
+		Conclusion:     jobRun.Conclusion.String,
+		FailureReason:  jobRun.FailureReason.String,
+		VMInstanceName: jobRun.VMInstanceName,
+		VMHost:         jobRun.VMHost,
+		QueuedAt:       jobRun.StartedAt,
+		QueueSeconds:   int64(jobRun.QueueDuration.Seconds()),
+		RunSeconds:     int64(jobRun.RunDuration.Seconds()),
+	}
+
+	if jobRun.ProcessingAt.Valid {
+		response.StartedAt = &jobRun.ProcessingAt.Time
+	}
+	if jobRun.EndedAt.Valid {
+		response.EndedAt = &jobRun.EndedAt.Time
+	}
+
+	return response
+}
+
+// ApiListRuns takes the same filters as the runs page, plus per_page
+func ApiListRuns(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	installationIds, err := models.UserInstallationIds(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch your installations"})
+		return
+	}
+
+	filter := runFilter(c)
+	if perPage, err := strconv.Atoi(c.Query("per_page")); err == nil && perPage > 0 && perPage <= maxApiRunsPerPage {
+		filter.PerPage = perPage
+	}
+
+	runs, total, err := models.FetchRuns(installationIds, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the runs"})
+		return
+	}
+
+	responses := make([]runResponse, 0, len(runs))
+	for i := range runs {
+		responses = append(responses, newRunResponse(&runs[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": responses, "total": total, "page": filter.Page, "per_page": filter.PerPage})
+}
+
+func ApiGetRun(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	jobRun, code, message := lookupUserRun(c, &user)
+	if jobRun == nil {
+		c.JSON(code, gin.H{"error": message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"run": newRunResponse(jobRun)})
+}
+
+// ApiCancelRun cancels a queued job's workflow run, for admins and owners of its installation
+func ApiCancelRun(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	jobRun, code, message := lookupUserRun(c, &user)
+	if jobRun == nil {
+		c.JSON(code, gin.H{"error": message})
+		return
+	}
+
+	if code, message := authorizeRun(&user, jobRun, models.RoleAdmin); code != 0 {
+		c.JSON(code, gin.H{"error": message})
+		return
+	}
+
+	appError := core.CancelPendingJob(jobRun)
+	if appError != nil {
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return
+	}
+
+	audit(c, "run.cancel", core.RunTarget(jobRun), core.RunSnapshot(jobRun), nil)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// ApiPool shows the shared VM pool, and how many of the user's jobs are waiting for it
+func ApiPool(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	installationIds, err := models.UserInstallationIds(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch your installations"})
+		return
+	}
+
+	pool, err := models.PoolSummary()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to summarise the pool"})
+		return
+	}
+
+	_, queued, err := models.FetchRuns(installationIds, models.RunFilter{Status: "queued", Page: 1, PerPage: 1})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count the queued jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pool": pool, "queued": queued})
+}
+
+// ApiUsage rolls up the month's usage of the user's installations, see GetUsage
+func ApiUsage(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	month, appError := core.ParseUsageMonth(c.Query("month"))
+	if appError != nil {
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return
+	}
+
+	installationIds, err := models.UserInstallationIds(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch your installations"})
+		return
+	}
+
+	rollups := make([]models.UsageRollup, 0)
+	for _, installationId := range installationIds {
+		installationRollups, err := models.MonthlyUsage(month, installationId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+		rollups = append(rollups, installationRollups...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"month": month.Format("2006-01"), "usage": rollups})
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// audit records an action taken on this request by the signed in user, the token's user or the internal token
+func audit(c *gin.Context, action string, target core.AuditTarget, before interface{}, after interface{}) {
+	event := models.AuditEvent{
+		ActorKind: models.AuditActorInternalApi,
+		Action:    action,
+		IpAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+	}
+
+	if user, exists := c.Get("user"); exists {
+		actor := user.(models.User)
+		event.ActorKind = models.AuditActorUser
+		event.ActorId = sql.NullInt64{Int64: actor.Id, Valid: true}
+		event.ActorLogin = actor.Login
+	}
+	if token, exists := c.Get("apiToken"); exists {
+		event.ActorKind = models.AuditActorApiToken
+		event.ApiTokenId = sql.NullInt64{Int64: token.(*models.ApiToken).Id, Valid: true}
+	}
+
+	core.RecordAudit(&event, target, before, after)
+}
+
+// AuditApiTokenUse records every request made with an API token, it runs after the token is authenticated
+func AuditApiTokenUse(c *gin.Context) {
+	token := c.MustGet("apiToken").(*models.ApiToken)
+	audit(c, "api_token.use", core.ApiTokenTarget(token.Id), nil, nil)
+}
+
+const auditEventsPerPage = 100
+
+// HandleAdminAudit shows operators the audit log, filtered by action, actor, installation and date
+func HandleAdminAudit(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	isProduction, _ := c.Get("isProduction")
+
+	filter := auditFilter(c)
+	events, total, err := models.FetchAuditEvents(filter)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to fetch the audit log")
+		return
+	}
+
+	actions, _ := models.AuditActions()
+	installations, _ := models.FetchAllInstallations()
+
+	pages := int((total + int64(filter.PerPage) - 1) / int64(filter.PerPage))
+	headers := gin.H{
+		"user":          user,
+		"events":        events,
+		"total":         total,
+		"actions":       actions,
+		"installations": installations,
+		"filter":        filter,
+		"actor":         c.Query("actor"),
+		"from":          c.Query("from"),
+		"to":            c.Query("to"),
+		"exportUrl":     "/admin/audit/export?" + c.Request.URL.Query().Encode(),
+		"page":          filter.Page,
+		"pages":         pages,
+		"isProduction":  isProduction.(bool),
+	}
+
+	if filter.Page > 1 {
+		headers["previousPageUrl"] = pageUrl("/admin/audit", c.Request.URL.Query(), filter.Page-1)
+	}
+	if filter.Page < pages {
+		headers["nextPageUrl"] = pageUrl("/admin/audit", c.Request.URL.Query(), filter.Page+1)
+	}
+
+	c.HTML(http.StatusOK, "audit.html", withSession(c, headers))
+}
+
+// ExportAudit downloads every event matching the audit page's filter as CSV
+func ExportAudit(c *gin.Context) {
+	filter := auditFilter(c)
+	filter.PerPage = 0
+
+	events, _, err := models.FetchAuditEvents(filter)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to fetch the audit log")
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=audit-%s.csv", time.Now().UTC().Format("2006-01-02")))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"created_at", "actor_kind", "actor_id", "actor_login", "api_token_id", "action", "target_type", "target_id", "installation_id", "before", "after", "ip_address", "user_agent", "method", "path"})
+	for _, event := range events {
+		_ = w.Write([]string{
+			event.CreatedAt.UTC().Format(time.RFC3339),
+			string(event.ActorKind),
+			nullInt64String(event.ActorId),
+			event.ActorLogin,
+			nullInt64String(event.ApiTokenId),
+			event.Action,
+			event.TargetType,
+			event.TargetId,
+			nullInt64String(event.InstallationId),
+			event.Before.String,
+			event.After.String,
+			event.IpAddress,
+			event.UserAgent,
+			event.Method,
+			event.Path,
+		})
+	}
+	w.Flush()
+}
+
+// auditFilter reads the audit page's query string, anything it can't parse doesn't filter
+func auditFilter(c *gin.Context) models.AuditFilter {
+	filter := models.AuditFilter{
+		Action:  c.Query("action"),
+		Page:    1,
+		PerPage: auditEventsPerPage,
+	}
+
+	if actorId, err := strconv.ParseInt(c.Query("actor"), 10, 64); err == nil {
+		filter.ActorId = actorId
+	}
+	if installationId, err := strconv.ParseInt(c.Query("installation"), 10, 64); err == nil {
+		filter.InstallationId = installationId
+	}
+	if from, err := time.Parse(runsDateFormat, c.Query("from")); err == nil {
+		filter.From = from
+	}
+	if to, err := time.Parse(runsDateFormat, c.Query("to")); err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	if page, err := strconv.Atoi(c.Query("page")); err == nil && page > 0 {
+		filter.Page = page
+	}
+
+	return filter
+}
+
+func nullInt64String(value sql.NullInt64) string {
+	if !value.Valid {
+		return ""
+	}
+
+	return strconv.FormatInt(value.Int64, 10)
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"buildkansen/internal/events"
+	"buildkansen/models"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"time"
+)
+
+// eventsKeepAlive is how often an idle stream gets a comment, so proxies don't close it
+const eventsKeepAlive = 30 * time.Second
+
+// HandleEvents streams run changes of the user's installations, and VM pool changes, as server-sent events
+func HandleEvents(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user := userValue.(models.User)
+	installationIds, err := models.UserInstallationIds(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch your installations"})
+		return
+	}
+
+	subscription := events.Subscribe(installationIds)
+	defer events.Unsubscribe(subscription)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-subscription.Events:
+			c.SSEvent(event.Kind, event.Data)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
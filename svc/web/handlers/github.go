@@ -4,7 +4,10 @@ import (
 	"buildkansen/config"
 	"buildkansen/internal/core"
 	"buildkansen/internal/jobs"
+	"buildkansen/internal/priority"
 	"buildkansen/models"
+	mw "buildkansen/web/middleware"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -50,7 +53,9 @@ type githubActionsWorkflowWebhookEvent struct {
 		WorkflowName    string      `json:"workflow_name"`
 		Status          string      `json:"status"`
 		Conclusion      string      `json:"conclusion"`
-		RunAttempt      int8        `json:"run_attempt"`
+		HeadSha         string      `json:"head_sha"`
+		HeadBranch      string      `json:"head_branch"`
+		RunAttempt      int         `json:"run_attempt"`
 		CreatedAt       time.Time   `json:"created_at"`
 		StartedAt       time.Time   `json:"started_at"`
 		CompletedAt     time.Time   `json:"completed_at"`
@@ -86,14 +91,24 @@ func GithubAuthCallback(c *gin.Context) {
 	}
 
 	uId, _ := strconv.ParseInt(user.UserID, 10, 64)
-	appError, newUser := core.CreateOrUpdateUser(uId, user.Name, user.Email)
+	appError, newUser := core.CreateOrUpdateUser(uId, user.NickName, user.Name, user.Email)
 	if appError != nil {
 		c.JSON(appError.Code, gin.H{"error": appError.Message})
 		return
 	}
 
+	if core.RestoreAccount(uId) {
+		c.Set("user", *newUser)
+		audit(c, "account.restore", core.UserTarget(uId), nil, nil)
+	}
+
+	core.LinkMemberships(newUser, user.AccessToken)
+
+	// start a fresh session on every sign in, so nothing set before it, tokens included, carries over
 	session := sessions.Default(c)
+	session.Clear()
 	session.Set(config.C.AuthorizedUserInSessionKey, uId)
+	session.Set(config.C.SignedInAtInSessionKey, time.Now().Unix())
 	_ = session.Save()
 
 	if core.HasUserAlreadyInstalled(newUser) {
@@ -101,7 +116,7 @@ func GithubAuthCallback(c *gin.Context) {
 		return
 	}
 
-	c.Redirect(http.StatusFound, InstallationUrl())
+	c.Redirect(http.StatusFound, InstallationUrl(installState(c)))
 }
 
 func GithubAppsCallback(c *gin.Context) {
@@ -117,6 +132,13 @@ func GithubAppsCallback(c *gin.Context) {
 		return
 	}
 
+	expectedState, _ := sessions.Default(c).Get(config.C.InstallStateInSessionKey).(string)
+	state := queryParams.Get("state")
+	if len(expectedState) == 0 || subtle.ConstantTimeCompare([]byte(expectedState), []byte(state)) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid installation state, start the installation from the dashboard"})
+		return
+	}
+
 	installationId, err := strconv.ParseInt(queryParams.Get("installation_id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse installation id"})
@@ -135,12 +157,14 @@ func GithubAppsCallback(c *gin.Context) {
 		return
 	}
 
-	appError := core.CreateInstallation(user.Id, installationId)
+	appError := core.CreateInstallation(&user, installationId)
 	if appError != nil {
 		c.JSON(appError.Code, gin.H{"error": appError.Message})
 		return
 	}
 
+	audit(c, "installation.install", core.AuditTarget{Type: "installation", Id: strconv.FormatInt(installationId, 10)}, nil, nil)
+
 	c.Redirect(http.StatusFound, "/")
 }
 
@@ -173,7 +197,7 @@ func GithubHook(c *gin.Context) {
 	}
 
 	fmt.Printf("Received a workflow job webhook: %s", response.Action)
-	runnerName, found := core.FindValidRunnerName(response.WorkflowJob.Labels)
+	runnerLabels, found := core.MatchRunnerLabels(response.WorkflowJob.Labels)
 	if !found {
 		c.JSON(http.StatusAccepted, gin.H{})
 		return
@@ -190,13 +214,21 @@ func GithubHook(c *gin.Context) {
 	switch response.Action {
 	case "queued":
 		fmt.Println("Processing the 'queued' workflow job...")
-		jobs.NewJob(
+		jobPriority := priority.Compute(priority.Attributes{
+			WorkflowName:  workflowJob.WorkflowName,
+			Branch:        workflowJob.HeadBranch,
+			DefaultBranch: response.Repository.DefaultBranch,
+			Repository:    repository.FullName,
+			Labels:        workflowJob.Labels,
+		})
+		job := jobs.NewJob(
 			installation.AccountLogin,
 			repository.InternalId,
 			response.Repository.HtmlUrl,
 			installationId,
-			runnerName,
+			runnerLabels,
 			workflowJob.RunId,
+			workflowJob.RunAttempt,
 			workflowJob.WorkflowName,
 			workflowJob.Status,
 			workflowJob.Conclusion,
@@ -204,7 +236,21 @@ func GithubHook(c *gin.Context) {
 			workflowJob.Name,
 			workflowJob.HtmlUrl,
 			workflowJob.StartedAt,
-		).Enqueue()
+			workflowJob.HeadSha,
+			workflowJob.HeadBranch,
+			jobPriority,
+		)
+
+		var err error
+		if reason := core.CheckBudget(installation); len(reason) > 0 {
+			err = job.Refuse(reason)
+		} else {
+			err = job.Enqueue()
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue the workflow job"})
+			return
+		}
 	case "in_progress":
 		fmt.Println("Processing the 'in_progress' workflow job...")
 		go core.ProcessWorkflowRun(
@@ -215,7 +261,6 @@ func GithubHook(c *gin.Context) {
 		fmt.Println("Processing 'completed' workflow job...")
 		go core.CompleteWorkflow(
 			workflowJob.ID,
-			workflowJob.RunId,
 			workflowJob.Status,
 			workflowJob.Conclusion,
 			repository.InternalId,
@@ -225,16 +270,22 @@ func GithubHook(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
-func InstallationUrl() string {
+// InstallationUrl sends the user to install the app, with the session's state to check when GitHub sends them back
+func InstallationUrl(state string) string {
 	u := &url.URL{
 		Scheme: "https",
 		Host:   "github.com",
-		Path:   config.C.GithubAppInstallationBaseUrl,
+		Path:   config.C.GithubNewInstallationUrl,
 	}
 	rq := u.Query()
-	rq.Set("state", "1") // TODO: Add additional state as necessary
+	rq.Set("state", state)
 	rq.Set("redirect_uri", config.C.GithubAppRedirectUrl)
 	u.RawQuery = rq.Encode()
 
 	return u.String()
 }
+
+// installState is the random state the session sends along with app installs
+func installState(c *gin.Context) string {
+	return mw.SessionToken(c, config.C.InstallStateInSessionKey)
+}
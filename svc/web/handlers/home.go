@@ -2,6 +2,7 @@ package web
 
 import (
 	"buildkansen/config"
+	"buildkansen/internal/core"
 	"buildkansen/models"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -15,19 +16,40 @@ func HandleHome(c *gin.Context) {
 	if exists {
 		user, _ := userValue.(models.User)
 		installations, repositories, runs := models.FetchUserData(&user)
+		notifications, _ := models.FetchUnreadNotifications(installations)
+		runnerLabelSets, _ := models.RunnerLabelSets()
+		runnerWarnings := core.RunnerVersionWarnings()
+		minutesUsed := core.MinutesUsed(installations)
+		pool, _ := models.PoolSummary()
+		apiTokens, _ := models.FetchApiTokens(user.Id)
+		memberships, _ := models.FetchMemberships(user.Id)
+		members, _ := models.FetchInstallationMembers(installationIds(installations))
+		deletedInstallations, _ := models.FetchDeletedInstallations(user.Id, core.DeletionGraceStart())
 
 		headers := gin.H{
-			"user":            user,
-			"dataAvailable":   haveAvailableInstallationData(installations, repositories),
-			"installationUrl": InstallationUrl(),
-			"installations":   installations,
-			"repositories":    repositories,
-			"runs":            runs,
-			"runnerLabels":    config.C.ValidRunnerNames,
-			"isProduction":    isProduction.(bool),
+			"user":                 user,
+			"dataAvailable":        haveAvailableInstallationData(installations, repositories),
+			"installationUrl":      InstallationUrl(installState(c)),
+			"installations":        installations,
+			"repositories":         repositories,
+			"runs":                 runs,
+			"notifications":        notifications,
+			"runnerLabelSets":      runnerLabelSets,
+			"runnerWarnings":       runnerWarnings,
+			"minutesUsed":          minutesUsed,
+			"pool":                 pool,
+			"apiTokens":            apiTokens,
+			"memberships":          memberships,
+			"members":              members,
+			"deletedInstallations": deletedInstallations,
+			"deletionGraceDays":    config.C.DeletionGraceDays,
+			"roles":                models.Roles,
+			"apiScopes":            models.ApiScopes,
+			"recentRunsLimit":      models.RecentRunsLimit,
+			"isProduction":         isProduction.(bool),
 		}
 
-		c.HTML(http.StatusOK, "index.html", headers)
+		c.HTML(http.StatusOK, "index.html", withSession(c, headers))
 	} else {
 		c.HTML(http.StatusOK, "login.html", gin.H{"isProduction": isProduction.(bool)})
 	}
@@ -41,9 +63,18 @@ func haveAvailableInstallationData(installations []models.Installation, reposito
 	return true
 }
 
+func installationIds(installations []models.Installation) []int64 {
+	ids := make([]int64, 0, len(installations))
+	for _, installation := range installations {
+		ids = append(ids, installation.InternalId)
+	}
+
+	return ids
+}
+
 func HandleLogout(c *gin.Context) {
 	session := sessions.Default(c)
-	session.Delete(config.C.AuthorizedUserInSessionKey)
+	session.Clear()
 	_ = session.Save()
 
 	c.Redirect(http.StatusFound, "/")
@@ -54,13 +85,34 @@ func HandleAccountDestroy(c *gin.Context) {
 
 	if exists {
 		user, _ := userValue.(models.User)
+		memberships, _ := models.FetchMemberships(user.Id)
 		err := models.DestroyUserData(&user)
 
 		if err != nil {
 			c.String(http.StatusNotFound, "Failed to destroy user data")
 			return
 		}
+
+		audit(c, "account.destroy", core.UserTarget(user.Id), accountSnapshot(&user, memberships), nil)
+
+		session := sessions.Default(c)
+		session.Clear()
+		_ = session.Save()
 	}
 
 	c.Redirect(http.StatusFound, "/")
 }
+
+// accountSnapshot is what the audit log keeps of a deleted account
+func accountSnapshot(user *models.User, memberships []models.Membership) gin.H {
+	installations := make([]gin.H, 0, len(memberships))
+	for _, membership := range memberships {
+		installations = append(installations, gin.H{
+			"installation_id": membership.Installation.Id,
+			"account_login":   membership.Installation.AccountLogin,
+			"role":            membership.Role,
+		})
+	}
+
+	return gin.H{"id": user.Id, "login": user.Login, "name": user.Name, "installations": installations}
+}
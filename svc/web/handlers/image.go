@@ -0,0 +1,133 @@
+package web
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/internal/labels"
+	"buildkansen/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+type imageRequest struct {
+	Name          string    `json:"name"`
+	Version       string    `json:"version"`
+	MacOSVersion  string    `json:"macos_version"`
+	XcodeVersion  string    `json:"xcode_version"`
+	RunnerVersion string    `json:"runner_version"`
+	Labels        string    `json:"labels"`
+	Checksum      string    `json:"checksum"`
+	BuiltAt       time.Time `json:"built_at"`
+}
+
+type promoteRequest struct {
+	ImageId int64 `json:"image_id"`
+	Percent int   `json:"percent"`
+}
+
+func ListImages(c *gin.Context) {
+	images, err := models.FetchImages()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+
+	rollouts, err := models.FetchRollouts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rollouts"})
+		return
+	}
+
+	runtimes, err := models.FetchLabelRuntimes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the labels' maximum runtimes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"images": images, "rollouts": rollouts, "runtimes": runtimes})
+}
+
+func PublishImage(c *gin.Context) {
+	var request imageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse request body"})
+		return
+	}
+
+	if request.BuiltAt.IsZero() {
+		request.BuiltAt = time.Now()
+	}
+
+	image := models.Image{
+		Name:          request.Name,
+		Version:       request.Version,
+		MacOSVersion:  request.MacOSVersion,
+		XcodeVersion:  request.XcodeVersion,
+		RunnerVersion: request.RunnerVersion,
+		Labels:        labels.Join(labels.Parse(request.Labels)),
+		Checksum:      request.Checksum,
+		BuiltAt:       request.BuiltAt,
+	}
+
+	appError := core.PublishImage(&image)
+	if appError != nil {
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return
+	}
+
+	audit(c, "image.publish", core.ImageTarget(&image), nil, image)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "image": image})
+}
+
+func PromoteImage(c *gin.Context) {
+	var request promoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse request body"})
+		return
+	}
+
+	rollout, appError := core.PromoteImage(c.Param("label"), request.ImageId, request.Percent)
+	if appError != nil {
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return
+	}
+
+	audit(c, "rollout.promote", core.LabelTarget(c.Param("label")), nil, rollout)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "rollout": rollout})
+}
+
+type maxRuntimeRequest struct {
+	MaxRuntimeMinutes int `json:"max_runtime_minutes"`
+}
+
+func SetMaxRuntime(c *gin.Context) {
+	var request maxRuntimeRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.MaxRuntimeMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a max_runtime_minutes of 0 or more"})
+		return
+	}
+
+	runtime, appError := core.SetMaxRuntime(c.Param("label"), request.MaxRuntimeMinutes)
+	if appError != nil {
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return
+	}
+
+	audit(c, "rollout.max_runtime", core.LabelTarget(c.Param("label")), nil, runtime)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "runtime": runtime})
+}
+
+func RollbackImage(c *gin.Context) {
+	rollout, appError := core.RollbackImage(c.Param("label"))
+	if appError != nil {
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return
+	}
+
+	audit(c, "rollout.rollback", core.LabelTarget(c.Param("label")), nil, rollout)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "rollout": rollout})
+}
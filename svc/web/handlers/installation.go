@@ -0,0 +1,393 @@
+package web
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func HandleInstallationSettings(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	membership, ok := findMembership(c, &user, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	policy := models.CapacityPolicy(c.PostForm("capacity_policy"))
+	if policy != models.CapacityPolicyNotify && policy != models.CapacityPolicyCancel {
+		c.String(http.StatusUnprocessableEntity, "Invalid capacity policy")
+		return
+	}
+
+	before := installationSettings(&membership.Installation)
+	result := models.UpdateInstallationCapacityPolicy(&membership.Installation, policy)
+	if result.Error != nil {
+		c.String(http.StatusInternalServerError, "Failed to update the installation")
+		return
+	}
+
+	audit(c, "installation.settings", core.InstallationTarget(&membership.Installation), before, installationSettings(&membership.Installation))
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// HandleMemberRole lets an owner change the role of a member of the installation
+func HandleMemberRole(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	membership, ok := findMembership(c, &user, models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	memberId, err := strconv.ParseInt(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Member not found")
+		return
+	}
+
+	role := models.Role(c.PostForm("role"))
+	if !models.ValidRole(role) {
+		c.String(http.StatusUnprocessableEntity, "Invalid role")
+		return
+	}
+
+	err = models.UpdateMembershipRole(membership.InstallationId, memberId, role)
+	if !handleMembershipError(c, err) {
+		return
+	}
+
+	audit(c, "membership.role", memberTarget(membership, memberId), nil, gin.H{"role": role})
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// HandleMemberRemove lets an owner remove a member, or any member leave, the installation
+func HandleMemberRemove(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	memberId, err := strconv.ParseInt(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Member not found")
+		return
+	}
+
+	required := models.RoleOwner
+	if memberId == user.Id {
+		required = models.RoleViewer
+	}
+
+	membership, ok := findMembership(c, &user, required)
+	if !ok {
+		return
+	}
+
+	err = models.RemoveMembership(membership.InstallationId, memberId)
+	if !handleMembershipError(c, err) {
+		return
+	}
+
+	audit(c, "membership.remove", memberTarget(membership, memberId), nil, nil)
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// HandleInstallationDestroy lets an owner delete the installation's data for every member
+func HandleInstallationDestroy(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	membership, ok := findMembership(c, &user, models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	before := installationSettings(&membership.Installation)
+	result := models.DestroyInstallation(&membership.Installation)
+	if result.Error != nil {
+		c.String(http.StatusInternalServerError, "Failed to delete the installation")
+		return
+	}
+
+	audit(c, "installation.destroy", core.InstallationTarget(&membership.Installation), before, nil)
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// HandleInstallationRestore lets an owner bring back an installation deleted during the grace period
+func HandleInstallationRestore(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	internalId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Installation not found")
+		return
+	}
+
+	installation, appError := core.RestoreInstallation(&user, internalId)
+	if appError != nil {
+		c.String(appError.Code, appError.Message)
+		return
+	}
+
+	audit(c, "installation.restore", core.InstallationTarget(installation), nil, nil)
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// findMembership finds the user's membership of the installation in the id param, and checks it has the role
+func findMembership(c *gin.Context, user *models.User, role models.Role) (*models.Membership, bool) {
+	internalId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Installation not found")
+		return nil, false
+	}
+
+	membership, err := models.FindMembership(user.Id, internalId)
+	if err != nil {
+		c.String(http.StatusNotFound, "Installation not found")
+		return nil, false
+	}
+
+	if !membership.Allows(role) {
+		c.String(http.StatusForbidden, "You need to be an installation "+string(role)+" to do this")
+		return nil, false
+	}
+
+	return membership, true
+}
+
+// memberTarget is the member of the membership's installation an action was done to
+func memberTarget(membership *models.Membership, memberId int64) core.AuditTarget {
+	target := core.UserTarget(memberId)
+	target.InstallationId = membership.InstallationId
+
+	return target
+}
+
+// installationSettings is what the audit log keeps of an installation's settings before and after a change
+func installationSettings(installation *models.Installation) gin.H {
+	return gin.H{
+		"capacity_policy":       installation.CapacityPolicy,
+		"max_concurrent_jobs":   installation.MaxConcurrentJobs,
+		"scheduling_weight":     installation.SchedulingWeight,
+		"max_runtime_minutes":   installation.MaxRuntimeMinutes,
+		"monthly_minute_budget": installation.MonthlyMinuteBudget,
+		"soft_limit_percent":    installation.SoftLimitPercent,
+		"budget_policy":         installation.BudgetPolicy,
+	}
+}
+
+func handleMembershipError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, models.ErrLastOwner):
+		c.String(http.StatusUnprocessableEntity, "The installation needs another owner first")
+	case errors.Is(err, models.ErrNotMember):
+		c.String(http.StatusNotFound, "Member not found")
+	default:
+		c.String(http.StatusInternalServerError, "Failed to update the member")
+	}
+
+	return false
+}
+
+func HandleNotificationDismiss(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	notificationId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	result := models.DismissNotification(user.Id, notificationId)
+	if result.Error != nil {
+		c.String(http.StatusInternalServerError, "Failed to dismiss the notification")
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// installationLimitsRequest leaves the limits it doesn't send as they are
+type installationLimitsRequest struct {
+	MaxConcurrentJobs *int `json:"max_concurrent_jobs"`
+	SchedulingWeight  *int `json:"scheduling_weight"`
+	MaxRuntimeMinutes *int `json:"max_runtime_minutes"`
+}
+
+type repositoryLimitsRequest struct {
+	MaxConcurrentJobs int `json:"max_concurrent_jobs"`
+}
+
+// UpdateInstallationLimits sets the concurrency cap and scheduling weight of an installation, by its GitHub id
+func UpdateInstallationLimits(c *gin.Context) {
+	var request installationLimitsRequest
+	if err := c.ShouldBindJSON(&request); err != nil ||
+		(request.MaxConcurrentJobs != nil && *request.MaxConcurrentJobs < 0) ||
+		(request.SchedulingWeight != nil && *request.SchedulingWeight < 1) ||
+		(request.MaxRuntimeMinutes != nil && *request.MaxRuntimeMinutes < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a max_concurrent_jobs and max_runtime_minutes of 0 or more and a scheduling_weight of 1 or more"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Installation not found"})
+		return
+	}
+
+	i, err := models.FindEntityById(models.Installation{}, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Installation not found"})
+		return
+	}
+
+	installation := i.(models.Installation)
+	before := installationSettings(&installation)
+	result := models.UpdateInstallationLimits(&installation, request.MaxConcurrentJobs, request.SchedulingWeight, request.MaxRuntimeMinutes)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the installation"})
+		return
+	}
+
+	audit(c, "installation.limits", core.InstallationTarget(&installation), before, installationSettings(&installation))
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+type installationBudgetRequest struct {
+	MonthlyMinutes   int64  `json:"monthly_minutes"`
+	SoftLimitPercent *int   `json:"soft_limit_percent"`
+	Policy           string `json:"policy"`
+}
+
+// UpdateInstallationBudget sets the monthly minute budget of an installation, by its GitHub id
+func UpdateInstallationBudget(c *gin.Context) {
+	var request installationBudgetRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.MonthlyMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a monthly_minutes of 0 or more"})
+		return
+	}
+
+	softLimitPercent := 80
+	if request.SoftLimitPercent != nil {
+		softLimitPercent = *request.SoftLimitPercent
+	}
+	if softLimitPercent < 1 || softLimitPercent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a soft_limit_percent between 1 and 100"})
+		return
+	}
+
+	policy := models.BudgetPolicy(request.Policy)
+	if len(policy) == 0 {
+		policy = models.BudgetPolicyCheck
+	}
+	if policy != models.BudgetPolicyCheck && policy != models.BudgetPolicyCancel {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a policy of check or cancel"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Installation not found"})
+		return
+	}
+
+	i, err := models.FindEntityById(models.Installation{}, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Installation not found"})
+		return
+	}
+
+	installation := i.(models.Installation)
+	before := installationSettings(&installation)
+	result := models.UpdateInstallationBudget(&installation, request.MonthlyMinutes, softLimitPercent, policy)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the installation"})
+		return
+	}
+
+	audit(c, "installation.budget", core.InstallationTarget(&installation), before, installationSettings(&installation))
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// UpdateRepositoryLimits sets the concurrency cap of a repository, by its internal id
+func UpdateRepositoryLimits(c *gin.Context) {
+	var request repositoryLimitsRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.MaxConcurrentJobs < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a max_concurrent_jobs of 0 or more"})
+		return
+	}
+
+	installationId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Installation not found"})
+		return
+	}
+
+	i, err := models.FindEntityById(models.Installation{}, installationId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Installation not found"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("repositoryId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	repository, err := models.FindInstallationRepository(i.(models.Installation).InternalId, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	before := gin.H{"max_concurrent_jobs": repository.MaxConcurrentJobs}
+	result := models.UpdateRepositoryLimits(repository, request.MaxConcurrentJobs)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the repository"})
+		return
+	}
+
+	audit(c, "repository.limits", core.RepositoryTarget(repository), before, gin.H{"max_concurrent_jobs": request.MaxConcurrentJobs})
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
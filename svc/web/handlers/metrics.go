@@ -0,0 +1,16 @@
+package web
+
+import (
+	"buildkansen/internal/metrics"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func Metrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4")
+	c.Status(http.StatusOK)
+
+	if err := metrics.Write(c.Writer); err != nil {
+		c.Status(http.StatusInternalServerError)
+	}
+}
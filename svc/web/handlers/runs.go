@@ -0,0 +1,225 @@
+package web
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	runsPerPage    = 50
+	runsDateFormat = "2006-01-02"
+)
+
+func HandleRuns(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	isProduction, _ := c.Get("isProduction")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	installationIds, err := models.UserInstallationIds(&user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to fetch your installations")
+		return
+	}
+
+	filter := runFilter(c)
+	runs, total, err := models.FetchRuns(installationIds, filter)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to fetch the runs")
+		return
+	}
+
+	repositories, _ := models.InstallationRepositories(installationIds)
+	workflowNames, _ := models.RunWorkflowNames(installationIds)
+	runnerLabels, _ := models.RunnerLabels()
+
+	pages := int((total + int64(filter.PerPage) - 1) / int64(filter.PerPage))
+	headers := gin.H{
+		"user":          user,
+		"repositories":  repositories,
+		"workflowNames": workflowNames,
+		"runnerLabels":  runnerLabels,
+		"runs":          runs,
+		"total":         total,
+		"filter":        filter,
+		"from":          c.Query("from"),
+		"to":            c.Query("to"),
+		"page":          filter.Page,
+		"pages":         pages,
+		"isProduction":  isProduction.(bool),
+	}
+
+	if filter.Page > 1 {
+		headers["previousPageUrl"] = pageUrl("/runs", c.Request.URL.Query(), filter.Page-1)
+	}
+	if filter.Page < pages {
+		headers["nextPageUrl"] = pageUrl("/runs", c.Request.URL.Query(), filter.Page+1)
+	}
+
+	c.HTML(http.StatusOK, "runs.html", withSession(c, headers))
+}
+
+func HandleRun(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	isProduction, _ := c.Get("isProduction")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	jobRun, ok := findUserRun(c, &user)
+	if !ok {
+		return
+	}
+
+	attempts, _ := models.RunAttempts(jobRun)
+	code, _ := authorizeRun(&user, jobRun, models.RoleAdmin)
+
+	c.HTML(http.StatusOK, "run.html", withSession(c, gin.H{
+		"user":         user,
+		"run":          jobRun,
+		"attempts":     attempts,
+		"canCancel":    code == 0,
+		"isProduction": isProduction.(bool),
+	}))
+}
+
+// HandleRunLogs sends the user to GitHub's short-lived download link for the job's logs
+func HandleRunLogs(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	jobRun, ok := findUserRun(c, &user)
+	if !ok {
+		return
+	}
+
+	logsUrl, appError := core.WorkflowJobLogsUrl(jobRun)
+	if appError != nil {
+		c.String(appError.Code, appError.Message)
+		return
+	}
+
+	c.Redirect(http.StatusFound, logsUrl)
+}
+
+// HandleRunCancel takes a queued job off the queue and cancels its workflow run
+func HandleRunCancel(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	jobRun, ok := findUserRun(c, &user)
+	if !ok {
+		return
+	}
+
+	if code, message := authorizeRun(&user, jobRun, models.RoleAdmin); code != 0 {
+		c.String(code, message)
+		return
+	}
+
+	appError := core.CancelPendingJob(jobRun)
+	if appError != nil {
+		c.String(appError.Code, appError.Message)
+		return
+	}
+
+	audit(c, "run.cancel", core.RunTarget(jobRun), core.RunSnapshot(jobRun), nil)
+
+	c.Redirect(http.StatusFound, "/runs/"+c.Param("id"))
+}
+
+func findUserRun(c *gin.Context, user *models.User) (*models.WorkflowJobRun, bool) {
+	jobRun, code, message := lookupUserRun(c, user)
+	if jobRun == nil {
+		c.String(code, message)
+		return nil, false
+	}
+
+	return jobRun, true
+}
+
+// lookupUserRun finds the run in the id param among the user's installations, or says why it can't
+func lookupUserRun(c *gin.Context, user *models.User) (*models.WorkflowJobRun, int, string) {
+	internalId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, http.StatusNotFound, "Run not found"
+	}
+
+	installationIds, err := models.UserInstallationIds(user)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to fetch your installations"
+	}
+
+	jobRun, err := models.FindRun(installationIds, internalId)
+	if err != nil {
+		return nil, http.StatusNotFound, "Run not found"
+	}
+
+	return jobRun, 0, ""
+}
+
+// authorizeRun checks the user's role on the run's installation is at least the given one, or says why not
+func authorizeRun(user *models.User, jobRun *models.WorkflowJobRun, role models.Role) (int, string) {
+	membership, err := models.FindMembership(user.Id, jobRun.Repository.InstallationId)
+	if err != nil {
+		return http.StatusNotFound, "Run not found"
+	}
+
+	if !membership.Allows(role) {
+		return http.StatusForbidden, "You need to be an installation " + string(role) + " to do this"
+	}
+
+	return 0, ""
+}
+
+// runFilter reads the runs page's query string, anything it can't parse doesn't filter
+func runFilter(c *gin.Context) models.RunFilter {
+	filter := models.RunFilter{
+		WorkflowName: c.Query("workflow"),
+		Status:       c.Query("status"),
+		Conclusion:   c.Query("conclusion"),
+		Label:        c.Query("label"),
+		Sort:         c.Query("sort"),
+		Ascending:    c.Query("order") == "asc",
+		Page:         1,
+		PerPage:      runsPerPage,
+	}
+
+	if repositoryId, err := strconv.ParseInt(c.Query("repository"), 10, 64); err == nil {
+		filter.RepositoryId = repositoryId
+	}
+	if from, err := time.Parse(runsDateFormat, c.Query("from")); err == nil {
+		filter.From = from
+	}
+	if to, err := time.Parse(runsDateFormat, c.Query("to")); err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	if page, err := strconv.Atoi(c.Query("page")); err == nil && page > 0 {
+		filter.Page = page
+	}
+
+	return filter
+}
+
+func pageUrl(path string, query url.Values, page int) string {
+	query.Set("page", strconv.Itoa(page))
+	return path + "?" + query.Encode()
+}
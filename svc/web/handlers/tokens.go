@@ -0,0 +1,68 @@
+package web
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func HandleTokenCreate(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	isProduction, _ := c.Get("isProduction")
+	validDays, err := strconv.Atoi(c.PostForm("valid_days"))
+	if err != nil {
+		c.String(http.StatusUnprocessableEntity, "Invalid validity")
+		return
+	}
+
+	plain, token, appError := core.IssueApiToken(&user, c.PostForm("name"), c.PostFormArray("scopes"), validDays)
+	if appError != nil {
+		c.String(appError.Code, appError.Message)
+		return
+	}
+
+	audit(c, "api_token.create", core.ApiTokenTarget(token.Id), nil, gin.H{"name": token.Name, "scopes": token.Scopes, "expires_at": token.ExpiresAt.Time})
+
+	// the token is shown in this response only, it is never stored in the session cookie
+	c.Header("Cache-Control", "no-store")
+	c.HTML(http.StatusOK, "token.html", withSession(c, gin.H{
+		"user":         user,
+		"token":        token,
+		"plainToken":   plain,
+		"isProduction": isProduction.(bool),
+	}))
+}
+
+func HandleTokenRevoke(c *gin.Context) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	user, _ := userValue.(models.User)
+	tokenId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Token not found")
+		return
+	}
+
+	result := models.RevokeApiToken(user.Id, tokenId)
+	if result.Error != nil {
+		c.String(http.StatusInternalServerError, "Failed to revoke the token")
+		return
+	}
+	if result.RowsAffected > 0 {
+		audit(c, "api_token.revoke", core.ApiTokenTarget(tokenId), nil, nil)
+	}
+
+	c.Redirect(http.StatusFound, "/#api-tokens")
+}
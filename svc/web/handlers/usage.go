@@ -0,0 +1,82 @@
+package web
+
+import (
+	"buildkansen/internal/core"
+	"buildkansen/models"
+	"encoding/csv"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// usageQuery reads the month and optional GitHub installation_id the usage endpoints are scoped to
+func usageQuery(c *gin.Context) (time.Time, int64, bool) {
+	month, appError := core.ParseUsageMonth(c.Query("month"))
+	if appError != nil {
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return time.Time{}, 0, false
+	}
+
+	githubId, _ := strconv.ParseInt(c.Query("installation_id"), 10, 64)
+	installationId, appError := core.FindUsageInstallation(githubId)
+	if appError != nil {
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return time.Time{}, 0, false
+	}
+
+	return month, installationId, true
+}
+
+func GetUsage(c *gin.Context) {
+	month, installationId, ok := usageQuery(c)
+	if !ok {
+		return
+	}
+
+	rollups, err := models.MonthlyUsage(month, installationId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"month": month.Format("2006-01"), "usage": rollups})
+}
+
+func ExportUsage(c *gin.Context) {
+	month, installationId, ok := usageQuery(c)
+	if !ok {
+		return
+	}
+
+	records, err := models.MonthlyUsageRecords(month, installationId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=usage-%s.csv", month.Format("2006-01")))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"installation_id", "account_login", "repository", "workflow", "job", "job_id", "label", "host", "started_at", "ended_at", "vm_seconds", "billable_minutes"})
+	for _, record := range records {
+		_ = w.Write([]string{
+			strconv.FormatInt(record.Installation.Id, 10),
+			record.Installation.AccountLogin,
+			record.Repository,
+			record.WorkflowName,
+			record.JobName,
+			strconv.FormatInt(record.JobId, 10),
+			record.Label,
+			record.Host,
+			record.StartedAt.UTC().Format(time.RFC3339),
+			record.EndedAt.UTC().Format(time.RFC3339),
+			strconv.FormatInt(record.VMSeconds, 10),
+			strconv.FormatInt(record.BillableMinutes, 10),
+		})
+	}
+	w.Flush()
+}
@@ -1,17 +1,21 @@
 package web
 
 import (
-	"buildkansen/models"
+	"buildkansen/internal/core"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
+	"os"
 )
 
 type vmRequest struct {
 	BaseVMName        string `json:"base_vm_name"`
 	GithubRunnerLabel string `json:"github_runner_label"`
+	Host              string `json:"host"`
+	Image             string `json:"image"`
+	ImageVersion      string `json:"image_version"`
 }
 
 func BindVM(c *gin.Context) {
@@ -20,16 +24,56 @@ func BindVM(c *gin.Context) {
 		return
 	}
 
-	result := models.CreateVM(response.BaseVMName, response.GithubRunnerLabel)
-	if result.Error != nil {
-		fmt.Println("Error create:", result.Error)
-		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Could not create VM"})
+	host := response.Host
+	if len(host) == 0 {
+		host, _ = os.Hostname()
+	}
+
+	vm, appError := core.BindVM(response.BaseVMName, response.GithubRunnerLabel, host, response.Image, response.ImageVersion)
+	if appError != nil {
+		fmt.Println("Error create:", appError.Error)
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
 		return
 	}
 
+	audit(c, "vm.bind", core.VMTarget(vm), nil, core.VMSnapshot(vm))
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
+// UnbindVM takes a host's free VMs parked from a base VM out of the pool, VMs running a job stay until they're free
+func UnbindVM(c *gin.Context) {
+	response := parseBody(c)
+	if response == nil {
+		return
+	}
+
+	host := response.Host
+	if len(host) == 0 {
+		host, _ = os.Hostname()
+	}
+
+	removed, busy, appError := core.UnbindVMs(response.BaseVMName, host)
+	if appError != nil {
+		fmt.Println("Error unbind:", appError.Error)
+		c.JSON(appError.Code, gin.H{"error": appError.Message})
+		return
+	}
+
+	if len(removed) == 0 {
+		if busy > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "The VM is running a job, try again once it's free"})
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": "VM not found"})
+		}
+		return
+	}
+
+	for i := range removed {
+		audit(c, "vm.unbind", core.VMTarget(&removed[i]), core.VMSnapshot(&removed[i]), nil)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "unbound": len(removed), "busy": busy})
+}
+
 func parseBody(c *gin.Context) *vmRequest {
 	body, err := io.ReadAll(c.Request.Body)
 
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"buildkansen/config"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	csrfFormField     = "csrf_token"
+	csrfHeader        = "X-CSRF-Token"
+	sessionTokenBytes = 32
+)
+
+// CsrfMiddleware refuses form posts that don't send back the session's CSRF token
+func CsrfMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected, _ := sessions.Default(c).Get(config.C.CsrfTokenInSessionKey).(string)
+
+		sent := c.PostForm(csrfFormField)
+		if len(sent) == 0 {
+			sent = c.GetHeader(csrfHeader)
+		}
+
+		if len(expected) == 0 || subtle.ConstantTimeCompare([]byte(expected), []byte(sent)) != 1 {
+			c.String(http.StatusForbidden, "Invalid CSRF token, reload the page and try again")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// SessionToken returns the random token the session keeps under the key, creating it the first time
+func SessionToken(c *gin.Context, key string) string {
+	session := sessions.Default(c)
+	if token, ok := session.Get(key).(string); ok && len(token) > 0 {
+		return token
+	}
+
+	secret := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	token := hex.EncodeToString(secret)
+	session.Set(key, token)
+	_ = session.Save()
+
+	return token
+}
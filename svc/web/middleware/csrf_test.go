@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"buildkansen/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+func csrfRouter() *gin.Engine {
+	config.C = &config.AppConfig{CsrfTokenInSessionKey: "CSRF Token"}
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(sessions.Sessions("test", cookie.NewStore([]byte("test session secret"))))
+	r.GET("/token", func(c *gin.Context) {
+		c.String(http.StatusOK, SessionToken(c, config.C.CsrfTokenInSessionKey))
+	})
+	r.POST("/action", CsrfMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, "done")
+	})
+
+	return r
+}
+
+// signIn opens a session and returns its cookie and CSRF token
+func signIn(t *testing.T, r *gin.Engine) (string, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/token", nil))
+
+	token := w.Body.String()
+	if len(token) != 2*sessionTokenBytes {
+		t.Fatalf("got token %q, want %d hex characters", token, 2*sessionTokenBytes)
+	}
+
+	return w.Header().Get("Set-Cookie"), token
+}
+
+func post(r *gin.Engine, sessionCookie string, form url.Values, header string) int {
+	req := httptest.NewRequest(http.MethodPost, "/action", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(sessionCookie) > 0 {
+		req.Header.Set("Cookie", sessionCookie)
+	}
+	if len(header) > 0 {
+		req.Header.Set(csrfHeader, header)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestCsrfAcceptsSessionToken(t *testing.T) {
+	r := csrfRouter()
+	sessionCookie, token := signIn(t, r)
+
+	if code := post(r, sessionCookie, url.Values{csrfFormField: {token}}, ""); code != http.StatusOK {
+		t.Errorf("form field: got %d, want 200", code)
+	}
+	if code := post(r, sessionCookie, url.Values{}, token); code != http.StatusOK {
+		t.Errorf("header: got %d, want 200", code)
+	}
+}
+
+func TestCsrfRefusesWrongToken(t *testing.T) {
+	r := csrfRouter()
+	sessionCookie, token := signIn(t, r)
+
+	if code := post(r, sessionCookie, url.Values{}, ""); code != http.StatusForbidden {
+		t.Errorf("no token: got %d, want 403", code)
+	}
+	if code := post(r, sessionCookie, url.Values{csrfFormField: {token[1:] + "0"}}, ""); code != http.StatusForbidden {
+		t.Errorf("wrong token: got %d, want 403", code)
+	}
+}
+
+func TestCsrfRefusesWithoutSession(t *testing.T) {
+	r := csrfRouter()
+	_, token := signIn(t, r)
+
+	// a token lifted from another session is no good without that session's cookie
+	if code := post(r, "", url.Values{csrfFormField: {token}}, ""); code != http.StatusForbidden {
+		t.Errorf("got %d, want 403", code)
+	}
+	if code := post(r, "", url.Values{csrfFormField: {""}}, ""); code != http.StatusForbidden {
+		t.Errorf("empty token and session: got %d, want 403", code)
+	}
+}
+
+func TestSessionTokenIsStable(t *testing.T) {
+	r := csrfRouter()
+	sessionCookie, token := signIn(t, r)
+
+	req := httptest.NewRequest(http.MethodGet, "/token", nil)
+	req.Header.Set("Cookie", sessionCookie)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Body.String() != token {
+		t.Errorf("the session's token changed from %q to %q", token, w.Body.String())
+	}
+}
@@ -2,12 +2,14 @@ package middleware
 
 import (
 	"buildkansen/config"
+	"buildkansen/internal/core"
 	"buildkansen/models"
 	"fmt"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strings"
+	"time"
 )
 
 func InternalApiAuthMiddleware() gin.HandlerFunc {
@@ -29,7 +31,7 @@ func InternalApiAuthMiddleware() gin.HandlerFunc {
 
 		token := authHeader[len(prefix):]
 
-		if token != config.C.InternalApiToken {
+		if !config.C.IsInternalApiToken(token) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
@@ -39,6 +41,36 @@ func InternalApiAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// ApiTokenAuthMiddleware lets requests with an active personal access token that has the scope through
+func ApiTokenAuthMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		const prefix = "Bearer "
+		authHeader := c.GetHeader("Authorization")
+		if !strings.HasPrefix(authHeader, prefix) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
+			c.Abort()
+			return
+		}
+
+		token := core.AuthenticateApiToken(authHeader[len(prefix):])
+		if token == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		if !token.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The token lacks the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user", token.User)
+		c.Set("apiToken", token)
+		c.Next()
+	}
+}
+
 func InjectGithubProvider() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		q := c.Request.URL.Query()
@@ -54,6 +86,7 @@ func SetEnv() gin.HandlerFunc {
 	}
 }
 
+// SetUserFromSessionMiddleware sets the signed in user, or the user an operator is impersonating
 func SetUserFromSessionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, err := getUserFromSession(c)
@@ -62,18 +95,59 @@ func SetUserFromSessionMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		impersonated, err := getImpersonatedUser(c, user.(models.User))
+		if err == nil {
+			if c.Request.Method != http.MethodGet {
+				c.String(http.StatusForbidden, "Impersonation is read-only")
+				c.Abort()
+				return
+			}
+
+			c.Set("impersonator", user)
+			user = impersonated
+		}
+
 		c.Set("user", user)
 		c.Next()
 	}
 }
 
+// AdminMiddleware lets the operators in ADMIN_USER_IDS through, as themselves even while they impersonate someone
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := getUserFromSession(c)
+		if err != nil {
+			c.Redirect(http.StatusFound, "/")
+			c.Abort()
+			return
+		}
+
+		admin := user.(models.User)
+		if !core.IsAdmin(&admin) {
+			c.String(http.StatusNotFound, "Not found")
+			c.Abort()
+			return
+		}
+
+		c.Set("user", admin)
+		c.Next()
+	}
+}
+
 func getUserFromSession(c *gin.Context) (interface{}, error) {
-	uId := sessions.Default(c).Get(config.C.AuthorizedUserInSessionKey)
+	session := sessions.Default(c)
+	uId := session.Get(config.C.AuthorizedUserInSessionKey)
 
 	if uId == nil {
 		return nil, fmt.Errorf("no user found")
 	}
 
+	if sessionExpired(session) {
+		session.Clear()
+		_ = session.Save()
+		return nil, fmt.Errorf("session expired")
+	}
+
 	result, err := models.FindEntityById(models.User{}, uId.(int64))
 	if err != nil {
 		return nil, err
@@ -81,3 +155,24 @@ func getUserFromSession(c *gin.Context) (interface{}, error) {
 
 	return result, nil
 }
+
+// sessionExpired is true once the session is older than SESSION_MAX_AGE_HOURS
+func sessionExpired(session sessions.Session) bool {
+	signedInAt, ok := session.Get(config.C.SignedInAtInSessionKey).(int64)
+	if !ok {
+		return true
+	}
+
+	maxAge := time.Duration(config.C.SessionMaxAgeHours) * time.Hour
+	return time.Since(time.Unix(signedInAt, 0)) > maxAge
+}
+
+func getImpersonatedUser(c *gin.Context, admin models.User) (interface{}, error) {
+	uId := sessions.Default(c).Get(config.C.ImpersonatedUserInSessionKey)
+
+	if uId == nil || !core.IsAdmin(&admin) {
+		return nil, fmt.Errorf("not impersonating")
+	}
+
+	return models.FindEntityById(models.User{}, uId.(int64))
+}
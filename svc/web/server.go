@@ -3,18 +3,22 @@ package web
 import (
 	"buildkansen/config"
 	"buildkansen/log"
+	"buildkansen/models"
 	. "buildkansen/web/handlers"
 	mw "buildkansen/web/middleware"
 	"embed"
+	"errors"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
 	gorrila "github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
 	"github.com/markbates/goth/providers/github"
 	"html/template"
 	"net/http"
+	"strings"
+	"time"
 )
 
 //go:embed assets views
@@ -24,6 +28,8 @@ const (
 	appPort         = ":8081"
 	certFilePath    = "./config/certs/localhost.pem"
 	certKeyFilePath = "./config/certs/localhost-key.pem"
+	// oauthMaxAge is how long, in seconds, a GitHub sign in can take
+	oauthMaxAge = 10 * 60
 )
 
 func Run() {
@@ -39,18 +45,66 @@ func Run() {
 		r.LoadHTMLGlob("./web/views/*")
 	}
 
-	store := cookie.NewStore([]byte(config.C.SessionSecret))
+	store := &cookieStore{newCookieStore()}
+	store.Options(sessionOptions())
 	r.Use(sessions.Sessions(config.C.SessionName, store))
 	initGithubAuth()
 
 	r.GET("/", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), HandleHome)
-	r.GET("/logout", mw.SetEnv(), HandleLogout)
-	r.POST("/account/destroy", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), HandleAccountDestroy)
+	r.POST("/logout", mw.SetEnv(), mw.CsrfMiddleware(), HandleLogout)
+	r.GET("/account/export", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), HandleAccountExport)
+	r.POST("/account/destroy", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleAccountDestroy)
+	r.POST("/installations/:id/settings", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleInstallationSettings)
+	r.POST("/installations/:id/destroy", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleInstallationDestroy)
+	r.POST("/installations/:id/restore", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleInstallationRestore)
+	r.POST("/installations/:id/members/:userId", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleMemberRole)
+	r.POST("/installations/:id/members/:userId/remove", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleMemberRemove)
+	r.POST("/notifications/:id/dismiss", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleNotificationDismiss)
+	r.GET("/runs", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), HandleRuns)
+	r.GET("/runs/:id", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), HandleRun)
+	r.GET("/runs/:id/logs", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), HandleRunLogs)
+	r.POST("/runs/:id/cancel", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleRunCancel)
+	r.POST("/tokens", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleTokenCreate)
+	r.POST("/tokens/:id/revoke", mw.SetEnv(), mw.CsrfMiddleware(), mw.SetUserFromSessionMiddleware(), HandleTokenRevoke)
+	r.GET("/events", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), HandleEvents)
+	r.GET("/analytics", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), HandleAnalytics)
+	r.GET("/analytics/durations", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), AnalyticsDurations)
+	r.GET("/analytics/throughput", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), AnalyticsThroughput)
+	r.GET("/analytics/failures", mw.SetEnv(), mw.SetUserFromSessionMiddleware(), AnalyticsFailures)
+	r.GET("/admin", mw.SetEnv(), mw.AdminMiddleware(), HandleAdmin)
+	r.GET("/admin/audit", mw.SetEnv(), mw.AdminMiddleware(), HandleAdminAudit)
+	r.GET("/admin/audit/export", mw.SetEnv(), mw.AdminMiddleware(), ExportAudit)
+	r.GET("/admin/analytics/utilisation", mw.SetEnv(), mw.AdminMiddleware(), AdminAnalyticsUtilisation)
+	r.POST("/admin/hosts/:name/drain", mw.SetEnv(), mw.CsrfMiddleware(), mw.AdminMiddleware(), AdminDrainHost)
+	r.POST("/admin/hosts/:name/undrain", mw.SetEnv(), mw.CsrfMiddleware(), mw.AdminMiddleware(), AdminUndrainHost)
+	r.POST("/admin/vms/:id/free", mw.SetEnv(), mw.CsrfMiddleware(), mw.AdminMiddleware(), AdminFreeVM)
+	r.POST("/admin/vms/:id/purge", mw.SetEnv(), mw.CsrfMiddleware(), mw.AdminMiddleware(), AdminPurgeVM)
+	r.POST("/admin/runs/:id/requeue", mw.SetEnv(), mw.CsrfMiddleware(), mw.AdminMiddleware(), AdminRequeueRun)
+	r.POST("/admin/runs/:id/cancel", mw.SetEnv(), mw.CsrfMiddleware(), mw.AdminMiddleware(), AdminCancelRun)
+	r.POST("/admin/users/:id/impersonate", mw.SetEnv(), mw.CsrfMiddleware(), mw.AdminMiddleware(), AdminImpersonate)
+	r.POST("/admin/impersonation/stop", mw.SetEnv(), mw.CsrfMiddleware(), mw.AdminMiddleware(), AdminStopImpersonating)
 	r.GET("/github/auth", mw.SetEnv(), mw.InjectGithubProvider(), GithubAuth)
 	r.GET("/github/auth/register", mw.SetEnv(), mw.InjectGithubProvider(), GithubAuthCallback)
 	r.GET("/github/apps/register", mw.SetEnv(), mw.InjectGithubProvider(), mw.SetUserFromSessionMiddleware(), GithubAppsCallback)
 	r.POST("/github/apps/hook", mw.SetEnv(), GithubHook)
+	r.GET("/v1/api/runs", mw.ApiTokenAuthMiddleware(models.ScopeRunsRead), AuditApiTokenUse, ApiListRuns)
+	r.GET("/v1/api/runs/:id", mw.ApiTokenAuthMiddleware(models.ScopeRunsRead), AuditApiTokenUse, ApiGetRun)
+	r.POST("/v1/api/runs/:id/cancel", mw.ApiTokenAuthMiddleware(models.ScopeRunsWrite), AuditApiTokenUse, ApiCancelRun)
+	r.GET("/v1/api/pool", mw.ApiTokenAuthMiddleware(models.ScopePoolRead), AuditApiTokenUse, ApiPool)
+	r.GET("/v1/api/usage", mw.ApiTokenAuthMiddleware(models.ScopeUsageRead), AuditApiTokenUse, ApiUsage)
 	r.PUT("/v1/api/internal/vm/bind", mw.SetEnv(), mw.InternalApiAuthMiddleware(), BindVM)
+	r.PUT("/v1/api/internal/vm/unbind", mw.SetEnv(), mw.InternalApiAuthMiddleware(), UnbindVM)
+	r.PUT("/v1/api/internal/installations/:id/limits", mw.SetEnv(), mw.InternalApiAuthMiddleware(), UpdateInstallationLimits)
+	r.PUT("/v1/api/internal/installations/:id/budget", mw.SetEnv(), mw.InternalApiAuthMiddleware(), UpdateInstallationBudget)
+	r.PUT("/v1/api/internal/installations/:id/repositories/:repositoryId/limits", mw.SetEnv(), mw.InternalApiAuthMiddleware(), UpdateRepositoryLimits)
+	r.GET("/v1/api/internal/usage", mw.SetEnv(), mw.InternalApiAuthMiddleware(), GetUsage)
+	r.GET("/v1/api/internal/usage/export", mw.SetEnv(), mw.InternalApiAuthMiddleware(), ExportUsage)
+	r.GET("/metrics", mw.SetEnv(), mw.InternalApiAuthMiddleware(), Metrics)
+	r.GET("/v1/api/internal/images", mw.SetEnv(), mw.InternalApiAuthMiddleware(), ListImages)
+	r.PUT("/v1/api/internal/images", mw.SetEnv(), mw.InternalApiAuthMiddleware(), PublishImage)
+	r.POST("/v1/api/internal/rollouts/:label/promote", mw.SetEnv(), mw.InternalApiAuthMiddleware(), PromoteImage)
+	r.POST("/v1/api/internal/rollouts/:label/rollback", mw.SetEnv(), mw.InternalApiAuthMiddleware(), RollbackImage)
+	r.PUT("/v1/api/internal/rollouts/:label/max_runtime", mw.SetEnv(), mw.InternalApiAuthMiddleware(), SetMaxRuntime)
 
 	var err error
 
@@ -67,16 +121,75 @@ func Run() {
 }
 
 func initGithubAuth() {
-	gothic.Store = gorrila.NewCookieStore([]byte(config.C.SessionSecret))
+	store := newCookieStore()
+	store.Options = &gorrila.Options{
+		Path:     "/",
+		MaxAge:   oauthMaxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	gothic.Store = store
 	goth.UseProviders(
-		github.New(config.C.GithubClientID, config.C.GithubClientSecret, config.C.GithubAuthRedirectUrl),
+		github.New(config.C.GithubClientID, config.C.GithubClientSecret, config.C.GithubAuthRedirectUrl, "read:org"),
 	)
 }
 
+// cookieStore is gin's cookie session store, made from our codecs instead of key pairs
+type cookieStore struct {
+	*gorrila.CookieStore
+}
+
+func (s *cookieStore) Options(options sessions.Options) {
+	s.CookieStore.Options = options.ToGorillaOptions()
+}
+
+// newCookieStore signs cookies with the current session secret and still opens those of the previous one
+func newCookieStore() *gorrila.CookieStore {
+	store := gorrila.NewCookieStore()
+	store.Codecs = securecookie.CodecsFromPairs([]byte(config.C.SessionSecret), nil)
+
+	if len(config.C.PreviousSessionSecret) > 0 && time.Now().Before(config.C.PreviousSessionSecretUntil) {
+		previous := securecookie.New([]byte(config.C.PreviousSessionSecret), nil)
+		store.Codecs = append(store.Codecs, expiringCodec{Codec: previous, until: config.C.PreviousSessionSecretUntil})
+	}
+
+	return store
+}
+
+// expiringCodec stops opening cookies once until has passed
+type expiringCodec struct {
+	securecookie.Codec
+	until time.Time
+}
+
+func (c expiringCodec) Decode(name string, value string, dst interface{}) error {
+	if time.Now().After(c.until) {
+		return errors.New("the previous session secret has expired")
+	}
+
+	return c.Codec.Decode(name, value, dst)
+}
+
+// sessionOptions keeps the session cookie off plain HTTP and out of scripts
+func sessionOptions() sessions.Options {
+	return sessions.Options{
+		Path:     "/",
+		MaxAge:   int(config.C.SessionMaxAgeHours) * 60 * 60,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
 func templateFuncs() template.FuncMap {
 	return template.FuncMap{
 		"inc": func(i int) int {
 			return i + 1
 		},
+		"join": strings.Join,
+		"list": func(items ...string) []string {
+			return items
+		},
 	}
 }